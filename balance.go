@@ -0,0 +1,194 @@
+package btcman
+
+import (
+	"context"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+)
+
+// utxoBucketBounds are the lower bounds of the utxo size buckets of the wallet summary, in satoshi
+var utxoBucketBounds = []int64{0, 10_000, 100_000, 1_000_000, 10_000_000}
+
+// Balance is the value of the wallet in satoshi
+type Balance struct {
+	// Confirmed is the value of the confirmed utxos, the immature ones excluded
+	Confirmed int64
+	// Unconfirmed is the value change of the wallet by the mempool transactions, negative if they spend more than they receive
+	Unconfirmed int64
+	// Immature is the value of the confirmed utxos without the confirmations required by the confirmation policy
+	Immature int64
+	// Reserved is the value of the spendable utxos leased to in flight transactions
+	Reserved int64
+}
+
+// Spendable returns the value of the utxos available to new transactions
+func (b Balance) Spendable() int64 {
+	return b.Confirmed - b.Reserved
+}
+
+// UTXOBucket is the spendable utxos of a size range
+type UTXOBucket struct {
+	// MinValue is the inclusive lower bound of the range and MaxValue its exclusive upper bound, 0 if unbounded
+	MinValue int64
+	MaxValue int64
+	Count    int
+	Value    int64
+}
+
+// WalletSummary describes the spendable utxos of the wallet and the inscriptions they can fund
+type WalletSummary struct {
+	Balance   Balance
+	UTXOCount int
+	Buckets   []UTXOBucket
+	// DustCount is the number of utxos whose value doesn't pay the fee of spending them
+	DustCount int
+	// InscriptionCost is the estimated cost of inscribing the sample payload of the summary
+	InscriptionCost int64
+	// EstimatedInscriptions is the number of sample payload inscriptions the utxos above the utxo threshold can fund
+	EstimatedInscriptions int64
+}
+
+// GetBalance returns the balance of all the output scripts spendable by the keychain
+func (client *Client) GetBalance() (*Balance, error) {
+	balance := &Balance{}
+	for _, pkScript := range client.keychain.GetPkScripts() {
+		scriptBalance, err := client.IndexerClient.GetBalance(context.Background(), pkScript)
+		if err != nil {
+			return nil, err
+		}
+		balance.Confirmed += scriptBalance.Confirmed
+		balance.Unconfirmed += scriptBalance.Unconfirmed
+	}
+
+	utxos, blockchainHeight, err := client.listWalletUTXOs()
+	if err != nil {
+		return nil, err
+	}
+	mature := []*indexer.UTXO{}
+	for _, utxo := range utxos {
+		if utxo.Height <= 0 {
+			continue
+		}
+		isMature, err := client.isMature(utxo, blockchainHeight)
+		if err != nil {
+			return nil, err
+		}
+		if !isMature {
+			balance.Immature += utxo.Value
+			continue
+		}
+		mature = append(mature, utxo)
+	}
+	balance.Confirmed -= balance.Immature
+	balance.Reserved = client.reservedValue(mature)
+	return balance, nil
+}
+
+// reservedValue returns the value of the utxos leased to in flight transactions
+func (client *Client) reservedValue(utxos []*indexer.UTXO) int64 {
+	available := make(map[wire.OutPoint]bool)
+	for _, utxo := range client.utxoManager.available(utxos) {
+		outPoint, err := utxoOutPoint(utxo)
+		if err == nil {
+			available[outPoint] = true
+		}
+	}
+	reserved := int64(0)
+	for _, utxo := range utxos {
+		outPoint, err := utxoOutPoint(utxo)
+		if err == nil && !available[outPoint] {
+			reserved += utxo.Value
+		}
+	}
+	return reserved
+}
+
+// GetWalletSummary returns the balance, the spendable utxos by size and the number of inscriptions
+// of the sample payload they can fund at the inscription fee rates
+func (client *Client) GetWalletSummary(samplePayload []byte) (*WalletSummary, error) {
+	balance, err := client.GetBalance()
+	if err != nil {
+		return nil, err
+	}
+	utxos, err := client.spendableUTXOs()
+	if err != nil {
+		return nil, err
+	}
+	inscriptionCost, err := client.inscriptionCost(samplePayload)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &WalletSummary{
+		Balance:         *balance,
+		UTXOCount:       len(utxos),
+		Buckets:         make([]UTXOBucket, len(utxoBucketBounds)),
+		InscriptionCost: inscriptionCost,
+	}
+	for i, minValue := range utxoBucketBounds {
+		summary.Buckets[i].MinValue = minValue
+		if i+1 < len(utxoBucketBounds) {
+			summary.Buckets[i].MaxValue = utxoBucketBounds[i+1]
+		}
+	}
+	fundingValue := int64(0)
+	for _, utxo := range utxos {
+		for i := len(summary.Buckets) - 1; i >= 0; i-- {
+			if utxo.Value >= summary.Buckets[i].MinValue {
+				summary.Buckets[i].Count++
+				summary.Buckets[i].Value += utxo.Value
+				break
+			}
+		}
+		// every inscription spends its utxos in the commit transaction
+		effectiveValue := utxo.Value - client.inputVSize(utxo.PkScript)*inscriptionCommitFeeRate
+		if effectiveValue <= 0 {
+			summary.DustCount++
+			continue
+		}
+		// the utxos below the threshold are left to the consolidation
+		if float64(utxo.Value) < client.utxoThreshold {
+			continue
+		}
+		fundingValue += effectiveValue
+	}
+	summary.EstimatedInscriptions = fundingValue / inscriptionCost
+	return summary, nil
+}
+
+// inscriptionCost returns the value of the commit transaction outputs of a payload inscription and its fee
+// without the inputs, which are paid by the effective value of the utxos
+func (client *Client) inscriptionCost(payload []byte) (int64, error) {
+	opts := InscribeOptions{}
+	inscriptionData, err := opts.inscriptionData(payload, client.revealDestination(opts))
+	if err != nil {
+		return 0, err
+	}
+	revealOutValue, err := client.revealOutValue(opts)
+	if err != nil {
+		return 0, err
+	}
+	request := &InscriptionRequest{
+		CommitFeeRate:      inscriptionCommitFeeRate,
+		FeeRate:            inscriptionRevealFeeRate,
+		DataList:           []InscriptionData{inscriptionData},
+		SingleRevealTxOnly: true,
+		RevealOutValue:     revealOutValue,
+	}
+	commitTxOutputs, err := estimateCommitTxOutputs(client.netParams, request)
+	if err != nil {
+		return 0, err
+	}
+
+	commitTx := wire.NewMsgTx(wire.TxVersion)
+	cost := int64(0)
+	for _, txOut := range commitTxOutputs {
+		commitTx.AddTxOut(txOut)
+		cost += txOut.Value
+	}
+	commitTx.AddTxOut(wire.NewTxOut(0, client.keychain.GetPkScript()))
+	return cost + mempool.GetTxVirtualSize(btcutil.NewTx(commitTx))*inscriptionCommitFeeRate, nil
+}
@@ -0,0 +1,50 @@
+package btcman
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBalance(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	client := newTestWriterClient(t, mockIndexer, 50_000, 2_000_000, 100)
+	mockIndexer.On("GetBalance", mock.Anything, client.keychain.GetPkScript()).Return(&indexer.Balance{Confirmed: 2_050_100, Unconfirmed: -1_000}, nil)
+	mockIndexer.On("GetBalance", mock.Anything, mock.Anything).Return(&indexer.Balance{}, nil)
+
+	// the first utxo is leased to an in flight transaction
+	require.True(t, client.utxoManager.lease(wire.OutPoint{Hash: chainhash.HashH([]byte("funding 0"))}))
+
+	balance, err := client.GetBalance()
+	require.NoError(t, err)
+	assert.Equal(t, &Balance{Confirmed: 2_050_100, Unconfirmed: -1_000, Reserved: 50_000}, balance)
+	assert.EqualValues(t, 2_000_100, balance.Spendable())
+
+	summary, err := client.GetWalletSummary(make([]byte, 1_000))
+	require.NoError(t, err)
+	assert.Equal(t, *balance, summary.Balance)
+	assert.Equal(t, 2, summary.UTXOCount)
+	require.Len(t, summary.Buckets, len(utxoBucketBounds))
+	assert.Equal(t, UTXOBucket{MinValue: 0, MaxValue: 10_000, Count: 1, Value: 100}, summary.Buckets[0])
+	assert.Equal(t, UTXOBucket{MinValue: 1_000_000, MaxValue: 10_000_000, Count: 1, Value: 2_000_000}, summary.Buckets[3])
+	assert.Equal(t, 1, summary.DustCount)
+	require.Greater(t, summary.InscriptionCost, int64(0))
+	assert.Equal(t, (2_000_000-inputVSize(client.keychain.GetPkScript())*inscriptionCommitFeeRate)/summary.InscriptionCost, summary.EstimatedInscriptions)
+
+	// a larger payload costs more
+	largerSummary, err := client.GetWalletSummary(make([]byte, 10_000))
+	require.NoError(t, err)
+	assert.Greater(t, largerSummary.InscriptionCost, summary.InscriptionCost)
+
+	// the utxos without the required confirmations are immature
+	client.confirmations = ConfirmationPolicy{Spendable: 1_001}
+	balance, err = client.GetBalance()
+	require.NoError(t, err)
+	assert.Equal(t, &Balance{Confirmed: 0, Unconfirmed: -1_000, Immature: 2_050_100}, balance)
+}
@@ -7,17 +7,18 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/grail-rollup/btcman/common"
 	"github.com/grail-rollup/btcman/indexer"
 	"github.com/ledgerwatch/log/v3"
 
+	"github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 )
@@ -31,26 +32,81 @@ type Client struct {
 	address                  *btcutil.Address
 	IndexerClient            indexer.Indexerer
 	consolidationStopChannel chan struct{}
+	utxoManager              *utxoManager
+	coinSelector             CoinSelector
 	utxoThreshold            float64
+	confirmations            ConfirmationPolicy
+	headerStore              *HeaderStore
 	isDebug                  bool
 }
 
 func NewClient(cfg Config) (Clienter, error) {
-	logger := log.New("module", common.BTCMAN)
-	logger.SetHandler(log.StreamHandler(os.Stdout, log.TerminalFormat()))
-	isDebug := cfg.EnableDebug
+	logger := newLogger(cfg.EnableDebug)
 
-	if isDebug {
-		logger.Debug("Creating btcman")
+	isValid := IsValidBtcConfig(&cfg)
+	if !isValid {
+		return nil, errors.New("invalid config")
+	}
+
+	// Load network
+	network, err := loadNetwork(cfg.Net)
+	if err != nil {
+		return nil, err
+	}
+	// Load mode
+	mode, err := loadMode(cfg.Mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load coin selection
+	coinSelector, err := loadCoinSelector(cfg.CoinSelection)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load header checkpoint
+	headerCheckpoint, err := loadHeaderCheckpoint(&cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load address type
+	addressType, err := loadAddressType(cfg.AddressType)
+	if err != nil {
+		return nil, err
 	}
 
+	var keychain Keychainer
+	if len(cfg.MusigPublicKeys) > 0 {
+		// the signing rounds need a transport, writers must use NewClientWithKeychain
+		keychain, err = NewMusig2Keychain(&cfg, mode, network, logger, nil)
+	} else if len(cfg.MultisigPublicKeys) > 0 {
+		if cfg.AddressType == "" {
+			addressType = P2WSHAddress
+		}
+		// the cosigners of a writer are only the local key, a higher threshold needs NewClientWithKeychain
+		keychain, err = NewMultisigKeychain(&cfg, mode, addressType, network, logger)
+	} else {
+		keychain, err = NewKeychain(&cfg, mode, addressType, network, logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(cfg, mode, network, keychain, coinSelector, headerCheckpoint, logger), nil
+}
+
+// NewClientWithKeychain creates a client that signs with the provided keychain,
+// e.g. a multisig keychain with remote cosigners
+func NewClientWithKeychain(cfg Config, keychain Keychainer) (Clienter, error) {
+	logger := newLogger(cfg.EnableDebug)
+
 	isValid := IsValidBtcConfig(&cfg)
 	if !isValid {
 		return nil, errors.New("invalid config")
 	}
 
-	// Load default consolidation values
-	consolidationInterval, consolidationTransactionFee, utxoThreshold, minUtxoConsolidationAmount := loadConsolidationValues(&cfg)
 	// Load network
 	network, err := loadNetwork(cfg.Net)
 	if err != nil {
@@ -62,15 +118,41 @@ func NewClient(cfg Config) (Clienter, error) {
 		return nil, err
 	}
 
-	keychain, err := NewKeychain(&cfg, mode, network, logger)
+	// Load coin selection
+	coinSelector, err := loadCoinSelector(cfg.CoinSelection)
 	if err != nil {
 		return nil, err
 	}
-	address, err := indexer.PublicKeyToAddress(keychain.GetPublicKey(), network)
+
+	// Load header checkpoint
+	headerCheckpoint, err := loadHeaderCheckpoint(&cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	return newClient(cfg, mode, network, keychain, coinSelector, headerCheckpoint, logger), nil
+}
+
+// newLogger returns the btcman root logger
+func newLogger(isDebug bool) log.Logger {
+	logger := log.New("module", common.BTCMAN)
+	logger.SetHandler(log.StreamHandler(os.Stdout, log.TerminalFormat()))
+
+	if isDebug {
+		logger.Debug("Creating btcman")
+	}
+	return logger
+}
+
+// newClient connects to the indexer and starts the consolidation in writer mode
+func newClient(cfg Config, mode BtcmanMode, network *chaincfg.Params, keychain Keychainer, coinSelector CoinSelector, headerCheckpoint *HeaderCheckpoint, logger log.Logger) *Client {
+	isDebug := cfg.EnableDebug
+
+	// Load default consolidation values
+	consolidationInterval, consolidationTransactionFee, utxoThreshold, minUtxoConsolidationAmount := loadConsolidationValues(&cfg)
+
+	address := keychain.GetAddress()
+
 	indexer := indexer.NewIndexer(isDebug, logger)
 	indexer.Start(fmt.Sprintf("%s:%s", cfg.IndexerHost, cfg.IndexerPort))
 
@@ -84,9 +166,15 @@ func NewClient(cfg Config) (Clienter, error) {
 		address:                  &address,
 		IndexerClient:            indexer,
 		consolidationStopChannel: stopChannel,
+		utxoManager:              newUtxoManager(cfg.AllowUnconfirmedChange),
+		coinSelector:             coinSelector,
 		utxoThreshold:            float64(utxoThreshold),
+		confirmations:            loadConfirmationPolicy(&cfg, network),
 		isDebug:                  isDebug,
 	}
+	if headerCheckpoint != nil {
+		btcman.headerStore = NewHeaderStore(indexer, network, *headerCheckpoint)
+	}
 
 	if mode == WriterMode {
 		ticker := time.NewTicker(time.Second * time.Duration(consolidationInterval))
@@ -101,7 +189,7 @@ func NewClient(cfg Config) (Clienter, error) {
 					if isDebug {
 						logger.Debug("Trying to consolidate")
 					}
-					utxos, err := btcman.ListUnspent()
+					utxos, err := btcman.spendableUTXOs()
 					if err != nil {
 						logger.Error("Failed to list utxos", "err", err)
 					}
@@ -112,7 +200,12 @@ func NewClient(cfg Config) (Clienter, error) {
 		}()
 	}
 
-	return &btcman, nil
+	return &btcman
+}
+
+// SetCoinSelector replaces the coin selector funding the inscriptions, e.g. with a custom strategy
+func (client *Client) SetCoinSelector(coinSelector CoinSelector) {
+	client.coinSelector = coinSelector
 }
 
 // Shutdown closes the RPC client
@@ -121,25 +214,13 @@ func (client *Client) Shutdown() {
 	client.IndexerClient.Disconnect()
 }
 
-// getUTXO returns a UTXO spendable by address, consolidates the address utxo set if needed
-func (client *Client) getUTXO() (*indexer.UTXO, error) {
+// spendableUTXOs returns the utxos not leased to in flight transactions
+func (client *Client) spendableUTXOs() ([]*indexer.UTXO, error) {
 	utxos, err := client.ListUnspent()
 	if err != nil {
 		return nil, err
 	}
-	if len(utxos) == 0 {
-		return nil, fmt.Errorf("there are no UTXOs")
-	}
-
-	utxoIndex := client.getIndexOfUtxoAboveThreshold(client.utxoThreshold, utxos)
-	if utxoIndex == -1 {
-		return nil, fmt.Errorf("can't find utxo to inscribe")
-	}
-
-	utxo := utxos[utxoIndex]
-
-	client.logger.Info("UTXO for address was found")
-	return utxo, nil
+	return client.utxoManager.available(utxos), nil
 }
 
 // consolidateUTXOS combines multiple utxo in one if the utxos are under a specific threshold and over a specific count
@@ -150,6 +231,7 @@ func (client *Client) consolidateUTXOS(utxos []*indexer.UTXO, consolidationFee f
 	}
 
 	var inputs []btcjson.TransactionInput
+	var leased []wire.OutPoint
 	dustAmount := btcutil.Amount(546)
 	totalAmount := btcutil.Amount(0)
 
@@ -157,6 +239,11 @@ func (client *Client) consolidateUTXOS(utxos []*indexer.UTXO, consolidationFee f
 		amount := btcutil.Amount(utxo.Value)
 		thresholdAmount := btcutil.Amount(client.utxoThreshold)
 		if amount < thresholdAmount && amount > dustAmount {
+			outPoint, err := utxoOutPoint(utxo)
+			if err != nil || !client.utxoManager.lease(outPoint) {
+				continue
+			}
+			leased = append(leased, outPoint)
 			inputs = append(inputs, btcjson.TransactionInput{
 				Txid: utxo.TxHash,
 				Vout: uint32(utxo.TxPos),
@@ -171,6 +258,7 @@ func (client *Client) consolidateUTXOS(utxos []*indexer.UTXO, consolidationFee f
 	if len(inputs) < minUtxoCountConsolidate || totalAmount <= btcutil.Amount(consolidationFee) {
 		client.logger.Info("Not enough UTXOs under the specified amount to consolidate.", "utxos",
 			len(inputs), "minUtxoCount", minUtxoCountConsolidate, "utxoThreshold", client.utxoThreshold)
+		client.utxoManager.release(leased...)
 		return
 	}
 
@@ -178,80 +266,198 @@ func (client *Client) consolidateUTXOS(utxos []*indexer.UTXO, consolidationFee f
 
 	outputAmount := totalAmount - btcutil.Amount(consolidationFee*(float64(len(inputs))*0.1))
 
-	rawTx, err := client.createRawTransaction(inputs, &outputAmount, client.address)
+	rawTx, err := client.createRawTransaction(inputs, rawTxOutput{address: *client.address, amount: outputAmount})
 	if err != nil {
 		client.logger.Error("error creating raw transaction", "err", err)
+		client.utxoManager.release(leased...)
 		return
 	}
 
-	err = client.keychain.SignTransaction(rawTx, client.IndexerClient)
+	err = client.keychain.SignTransaction(rawTx, NewUtxoPrevOutFetcher(utxos, client.IndexerClient, client.logger))
 	if err != nil {
 		client.logger.Error("error signing raw transaction", "err", err)
+		client.utxoManager.release(leased...)
 		return
 	}
 
 	txHash, err := client.IndexerClient.SendTransaction(context.Background(), rawTx)
 	if err != nil {
 		client.logger.Error("error sending transaction", "err", err)
+		client.utxoManager.release(leased...)
 		return
 	}
+	client.utxoManager.spend(rawTx, client.keychain.GetPkScripts())
 	client.logger.Info("UTXOs consolidated successfully", "txHash", txHash)
 }
 
-// getUtxoAboveThreshold returns the index of utxo over a specific threshold from a utxo set, if doesn't exist returns -1
-func (client *Client) getIndexOfUtxoAboveThreshold(threshold float64, utxos []*indexer.UTXO) int {
-	for index, utxo := range utxos {
-		if float64(utxo.Value) >= threshold {
-			return index
-		}
+const (
+	// inscriptionCommitFeeRate is the fee rate of the commit transactions in sat/vbyte
+	inscriptionCommitFeeRate = 3
+	// inscriptionRevealFeeRate is the fee rate of the reveal transactions in sat/vbyte
+	inscriptionRevealFeeRate = 2
+)
+
+// createInscriptionRequest cretes the request for the insription with the inscription data
+func (client *Client) createInscriptionRequest(dataList []InscriptionData, opts InscribeOptions, singleRevealTxOnly bool) (*InscriptionRequest, error) {
+	revealOutValue, err := client.revealOutValue(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	request := InscriptionRequest{
+		CommitFeeRate:      inscriptionCommitFeeRate,
+		FeeRate:            inscriptionRevealFeeRate,
+		DataList:           dataList,
+		SingleRevealTxOnly: singleRevealTxOnly,
+		RevealOutValue:     revealOutValue,
+		RevealTag:          opts.RevealTag,
+	}
+
+	commitTxOutPointList, err := client.selectCommitTxOutPoints(&request)
+	if err != nil {
+		return nil, err
 	}
-	return -1
+	request.CommitTxOutPointList = commitTxOutPointList
+
+	return &request, nil
 }
 
-// createInscriptionRequest cretes the request for the insription with the inscription data
-func (client *Client) createInscriptionRequest(data []byte) (*InscriptionRequest, error) {
-	utxo, err := client.getUTXO()
+// selectCommitTxOutPoints leases the utxos funding the commit and reveal transactions of the request,
+// the leases must be released if the utxos aren't spent
+func (client *Client) selectCommitTxOutPoints(request *InscriptionRequest) ([]*wire.OutPoint, error) {
+	commitTxOutputs, err := estimateCommitTxOutputs(client.netParams, request)
 	if err != nil {
 		return nil, err
 	}
+	commitTx := wire.NewMsgTx(wire.TxVersion)
+	revealAmount := int64(0)
+	for _, txOut := range commitTxOutputs {
+		commitTx.AddTxOut(txOut)
+		revealAmount += txOut.Value
+	}
+	changeOutput := wire.NewTxOut(0, client.keychain.GetPkScript())
+	target := CoinSelectionTarget{
+		Amount:     revealAmount + mempool.GetTxVirtualSize(btcutil.NewTx(commitTx))*request.CommitFeeRate,
+		FeeRate:    request.CommitFeeRate,
+		ChangeCost: int64(changeOutput.SerializeSize()) * request.CommitFeeRate,
+	}
 
-	commitTxOutPoint := new(wire.OutPoint)
-	inTxid, err := chainhash.NewHashFromStr(utxo.TxHash)
+	// the utxos below the threshold are left to the consolidation
+	selected, err := client.leaseUTXOs(target, client.utxoThreshold)
 	if err != nil {
-		client.logger.Error("Failed to create inscription request")
 		return nil, err
 	}
+	outPoints := make([]*wire.OutPoint, len(selected))
+	for i, utxo := range selected {
+		outPoint, err := utxoOutPoint(utxo)
+		if err != nil {
+			return nil, err
+		}
+		outPoints[i] = &outPoint
+	}
+	return outPoints, nil
+}
 
-	commitTxOutPoint = wire.NewOutPoint(inTxid, uint32(utxo.TxPos))
+// leaseUTXOs selects and leases the utxos of at least threshold value covering the target,
+// the leases must be released if the utxos aren't spent
+func (client *Client) leaseUTXOs(target CoinSelectionTarget, threshold float64) ([]*indexer.UTXO, error) {
+	if target.InputVSize == nil {
+		target.InputVSize = client.inputVSize
+	}
+	// another transaction may lease a selected utxo in the meantime, the selection is then repeated
+	for attempt := 0; attempt < maxSelectionAttempts; attempt++ {
+		utxos, err := client.spendableUTXOs()
+		if err != nil {
+			return nil, err
+		}
+		if len(utxos) == 0 {
+			return nil, fmt.Errorf("there are no UTXOs")
+		}
+		utxos = utxosAboveThreshold(threshold, utxos)
+		if len(utxos) == 0 {
+			return nil, fmt.Errorf("there are no UTXOs above the threshold %v", threshold)
+		}
+		selected, err := client.coinSelector.Select(utxos, target)
+		if err != nil {
+			return nil, fmt.Errorf("can't find utxos to spend: %v", err)
+		}
 
-	dataList := make([]InscriptionData, 0)
+		leased := []wire.OutPoint{}
+		for _, utxo := range selected {
+			outPoint, err := utxoOutPoint(utxo)
+			if err != nil || !client.utxoManager.lease(outPoint) {
+				break
+			}
+			leased = append(leased, outPoint)
+		}
+		if len(leased) == len(selected) {
+			client.logger.Info("UTXOs for address were found", "utxos", len(selected))
+			return selected, nil
+		}
+		client.utxoManager.release(leased...)
+	}
+	return nil, fmt.Errorf("can't lease utxos to spend")
+}
 
-	dataList = append(dataList, InscriptionData{
-		ContentType: "application/octet-stream",
-		Body:        data,
-		Destination: (*client.address).String(),
-	})
+// utxosAboveThreshold returns the utxos with a value of at least threshold
+func utxosAboveThreshold(threshold float64, utxos []*indexer.UTXO) []*indexer.UTXO {
+	filtered := []*indexer.UTXO{}
+	for _, utxo := range utxos {
+		if float64(utxo.Value) >= threshold {
+			filtered = append(filtered, utxo)
+		}
+	}
+	return filtered
+}
 
-	request := InscriptionRequest{
-		CommitTxOutPointList: []*wire.OutPoint{commitTxOutPoint},
-		CommitFeeRate:        3,
-		FeeRate:              2,
-		DataList:             dataList,
-		SingleRevealTxOnly:   true,
-		// RevealOutValue:       500,
+// revealDestination returns the address receiving the inscriptions: the one of the options,
+// the configured inscription holding address or the wallet address
+func (client *Client) revealDestination(opts InscribeOptions) string {
+	if opts.Destination != "" {
+		return opts.Destination
 	}
-	return &request, nil
+	if client.cfg.InscriptionHoldingAddress != "" {
+		return client.cfg.InscriptionHoldingAddress
+	}
+	return (*client.address).String()
+}
+
+// revealOutValue returns the value of the reveal outputs: the one of the options, the configured one
+// or the minimal value that isn't dust for the destination script
+func (client *Client) revealOutValue(opts InscribeOptions) (int64, error) {
+	if opts.RevealOutValue > 0 {
+		return opts.RevealOutValue, nil
+	}
+	if client.cfg.RevealOutValue > 0 {
+		return int64(client.cfg.RevealOutValue), nil
+	}
+	destination, err := btcutil.DecodeAddress(client.revealDestination(opts), client.netParams)
+	if err != nil {
+		return 0, err
+	}
+	pkScript, err := txscript.PayToAddrScript(destination)
+	if err != nil {
+		return 0, err
+	}
+	return mempool.GetDustThreshold(wire.NewTxOut(0, pkScript)), nil
 }
 
 // createInscriptionTool returns a new inscription tool struct
-func (client *Client) createInscriptionTool(message []byte) (*InscriptionTool, error) {
-	request, err := client.createInscriptionRequest(message)
+func (client *Client) createInscriptionTool(dataList []InscriptionData, opts InscribeOptions, singleRevealTxOnly bool) (*InscriptionTool, error) {
+	request, err := client.createInscriptionRequest(dataList, opts, singleRevealTxOnly)
 	if err != nil {
 		return nil, err
 	}
 
-	tool, err := NewInscriptionTool(client.netParams, request, client.IndexerClient, client.keychain)
+	keychain := client.keychain
+	if opts.DryRun {
+		keychain = client.dryRunKeychain()
+	}
+	tool, err := NewInscriptionTool(client.netParams, request, client.IndexerClient, keychain)
 	if err != nil {
+		for _, outPoint := range request.CommitTxOutPointList {
+			client.utxoManager.release(*outPoint)
+		}
 		return nil, err
 	}
 	return tool, nil
@@ -259,127 +465,331 @@ func (client *Client) createInscriptionTool(message []byte) (*InscriptionTool, e
 
 // Inscribe creates an inscription of data into a btc transaction
 func (client *Client) Inscribe(data []byte) error {
-	tool, err := client.createInscriptionTool(data)
+	_, err := client.InscribeWithOptions(data, InscribeOptions{})
+	return err
+}
+
+// InscribeWithOptions creates an inscription of data into a btc transaction, opts set the optional envelope tags.
+// With opts.DryRun the signed transactions are returned without being sent, with opts.PublishMode set to OpReturnPublish
+// the data is written in OP_RETURN outputs instead of an inscription
+func (client *Client) InscribeWithOptions(data []byte, opts InscribeOptions) (*InscribeResult, error) {
+	if opts.PublishMode == OpReturnPublish {
+		return client.publishOpReturn(data, opts)
+	}
+	tool, err := client.inscriptionTool(data, opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if opts.DryRun {
+		return client.dryRun(tool)
 	}
 
-	commitTxHash, revealTxHashList, inscriptions, fees, err := tool.Inscribe()
+	result, err := client.inscribe(tool)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	revealTxHash := revealTxHashList[0]
-	inscription := inscriptions[0]
 
 	if client.isDebug {
-		client.logger.Debug("Successful inscription", "commitTx", commitTxHash.String(),
-			"revealTx", revealTxHash.String(), "inscription", inscription, "fees", fees)
+		client.logger.Debug("Successful inscription", "commitTx", result.CommitTxHash.String(),
+			"revealTx", result.RevealTxHashList[0].String(), "inscription", result.InscriptionIDs[0], "fees", result.Fees)
 	}
-
-	return nil
+	return result, nil
 }
 
-// DecodeInscription reads the inscribed message from BTC by a transaction hash
-func (client *Client) DecodeInscription(revealTxHash string) (string, error) {
-	tx, err := client.GetTransaction(revealTxHash, false)
+// InscribeBatch inscribes several payloads funded by a single commit transaction, opts apply to every payload.
+// With opts.SingleReveal all the inscriptions are revealed by one transaction, otherwise by one transaction each.
+// If a reveal transaction fails to be sent the partial result is returned together with the error
+func (client *Client) InscribeBatch(payloads [][]byte, opts InscribeOptions) (*InscribeResult, error) {
+	tool, err := client.batchInscriptionTool(payloads, opts)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if opts.DryRun {
+		return client.dryRun(tool)
 	}
-	inscriptionMessage, err := client.getInscriptionMessage(tx.Hex)
+
+	result, err := client.inscribe(tool)
 	if err != nil {
-		return "", err
+		return result, err
 	}
 
-	disasm, err := txscript.DisasmString(inscriptionMessage)
+	client.logger.Info("Batch inscribed successfully", "commitTx", result.CommitTxHash, "items", len(result.Items), "fees", result.Fees)
+	return result, nil
+}
+
+// EstimateInscription builds and signs the commit and reveal transactions of an inscription without sending them
+// and returns their sizes and fees, the transactions are signed as with opts.DryRun
+func (client *Client) EstimateInscription(data []byte, opts InscribeOptions) (*InscriptionEstimate, error) {
+	opts.DryRun = true
+	if opts.PublishMode == OpReturnPublish {
+		return client.estimateOpReturn(data, opts)
+	}
+	tool, err := client.inscriptionTool(data, opts)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer client.utxoManager.release(txOutPoints(tool.commitTx)...)
 
-	proof := strings.ReplaceAll(disasm, " ", "")
+	return tool.Estimate(), nil
+}
 
-	return proof, nil
+// inscriptionTool returns the inscription tool of a single payload, split in chunks if opts.Chunked
+func (client *Client) inscriptionTool(data []byte, opts InscribeOptions) (*InscriptionTool, error) {
+	dataList := make([]InscriptionData, 0)
+	if opts.Chunked {
+		// every chunk is revealed by its own transaction to stay below the standard weight
+		chunks, err := opts.chunkedInscriptionData(data, client.revealDestination(opts))
+		if err != nil {
+			return nil, err
+		}
+		dataList = append(dataList, chunks...)
+	} else {
+		inscriptionData, err := opts.inscriptionData(data, client.revealDestination(opts))
+		if err != nil {
+			return nil, err
+		}
+		dataList = append(dataList, inscriptionData)
+	}
+	return client.createInscriptionTool(dataList, opts, !opts.Chunked)
 }
 
-// getTransaction returns a transaction from BTC by a transaction hash
-func (client *Client) GetTransaction(txid string, verbose bool) (*btcjson.TxRawResult, error) {
-	return client.IndexerClient.GetTransaction(context.Background(), txid, verbose)
+// batchInscriptionTool returns the inscription tool of a batch of payloads
+func (client *Client) batchInscriptionTool(payloads [][]byte, opts InscribeOptions) (*InscriptionTool, error) {
+	if len(payloads) == 0 {
+		return nil, errors.New("batch has no payloads")
+	}
+	if opts.Chunked {
+		return nil, errors.New("chunked inscriptions can't be batched")
+	}
+	if opts.PublishMode != InscriptionPublish {
+		return nil, fmt.Errorf("publish mode %s can't be batched", opts.PublishMode)
+	}
+
+	dataList := make([]InscriptionData, len(payloads))
+	for i, payload := range payloads {
+		inscriptionData, err := opts.inscriptionData(payload, client.revealDestination(opts))
+		if err != nil {
+			return nil, fmt.Errorf("batch item %d: %v", i, err)
+		}
+		dataList[i] = inscriptionData
+	}
+	return client.createInscriptionTool(dataList, opts, opts.SingleReveal)
 }
 
-// getInscriptionMessage returns the raw inscribed message from the transaction
-func (client *Client) getInscriptionMessage(txHex string) ([]byte, error) {
-	txBytes, err := hex.DecodeString(txHex)
+// inscribe sends the commit and reveal transactions of the tool and returns the result of every inscription
+func (client *Client) inscribe(tool *InscriptionTool) (*InscribeResult, error) {
+	commitTxHash, revealTxHashList, _, fees, err := tool.Inscribe()
+	if commitTxHash == nil {
+		client.utxoManager.release(txOutPoints(tool.commitTx)...)
+		return nil, err
+	}
+	client.utxoManager.spend(tool.commitTx, client.keychain.GetPkScripts())
+
+	return newInscribeResult(tool, commitTxHash, revealTxHashList, fees), err
+}
+
+// dryRun returns the result of the signed transactions of the tool without sending them
+func (client *Client) dryRun(tool *InscriptionTool) (*InscribeResult, error) {
+	client.utxoManager.release(txOutPoints(tool.commitTx)...)
+
+	commitTxHex, err := tool.GetCommitTxHex()
 	if err != nil {
 		return nil, err
 	}
-	var targetTx wire.MsgTx
+	revealTxHexList, err := tool.GetRevealTxHexList()
+	if err != nil {
+		return nil, err
+	}
+
+	commitTxHash := tool.commitTx.TxHash()
+	revealTxHashList := make([]*chainhash.Hash, len(tool.revealTx))
+	for i, revealTx := range tool.revealTx {
+		revealTxHash := revealTx.TxHash()
+		revealTxHashList[i] = &revealTxHash
+	}
+
+	result := newInscribeResult(tool, &commitTxHash, revealTxHashList, tool.calculateFee())
+	result.CommitTxHex = commitTxHex
+	result.RevealTxHexList = revealTxHexList
+	result.Signed = client.dryRunSigned()
+	return result, nil
+}
 
-	err = targetTx.Deserialize(bytes.NewReader(txBytes))
+// newInscribeResult returns the result of every inscription of the tool, reveal transactions not sent are nil
+func newInscribeResult(tool *InscriptionTool, commitTxHash *chainhash.Hash, revealTxHashList []*chainhash.Hash, fees int64) *InscribeResult {
+	result := &InscribeResult{
+		CommitTxHash:     commitTxHash,
+		RevealTxHashList: revealTxHashList,
+		Fees:             fees,
+		Items:            make([]InscribeItemResult, len(tool.txCtxDataList)),
+		Signed:           true,
+	}
+	for i := range result.Items {
+		item := &result.Items[i]
+		item.RevealFee = tool.revealFee(i)
+
+		// a single reveal transaction carries the inscriptions in its inputs order
+		revealTxHash, index := revealTxHashList[0], i
+		if len(revealTxHashList) > 1 {
+			revealTxHash, index = revealTxHashList[i], 0
+		}
+		if revealTxHash == nil {
+			item.Err = errors.New("reveal transaction was not sent")
+			continue
+		}
+		item.RevealTxHash = revealTxHash
+		item.InscriptionID = InscriptionID{TxHash: *revealTxHash, Index: uint32(index)}.String()
+		result.InscriptionIDs = append(result.InscriptionIDs, item.InscriptionID)
+	}
+	return result
+}
+
+// DecodeInscription reads the first inscription of a reveal transaction from BTC by a transaction hash
+func (client *Client) DecodeInscription(revealTxHash string) (*Inscription, error) {
+	inscriptions, err := client.DecodeInscriptions(revealTxHash)
 	if err != nil {
 		return nil, err
 	}
-	if len(targetTx.TxIn) < 1 || len(targetTx.TxIn[0].Witness) < 2 {
+	return inscriptions[0], nil
+}
+
+// DecodeInscriptions reads all the inscriptions of a reveal transaction from BTC by a transaction hash
+func (client *Client) DecodeInscriptions(revealTxHash string) ([]*Inscription, error) {
+	targetTx, err := client.getMsgTx(revealTxHash)
+	if err != nil {
 		return nil, err
 	}
-	inscriptionHex := hex.EncodeToString(targetTx.TxIn[0].Witness[1])
 
-	const (
-		utfMarker       = "6170706c69636174696f6e2f6f637465742d73747265616d" // application/octet-stream
-		utfMarkerLength = 48
-	)
+	inscriptions := ParseInscriptions(targetTx)
+	if len(inscriptions) == 0 {
+		return nil, ErrNoInscription
+	}
+	return inscriptions, nil
+}
+
+// getTransaction returns a transaction from BTC by a transaction hash
+func (client *Client) GetTransaction(txid string, verbose bool) (*btcjson.TxRawResult, error) {
+	return client.IndexerClient.GetTransaction(context.Background(), txid, verbose)
+}
 
-	// Get the message from the inscription
-	markerIndex := strings.Index(inscriptionHex, utfMarker)
-	if markerIndex == -1 {
-		return nil, fmt.Errorf("inscription hex is invalid")
+// getMsgTx returns a decoded transaction from BTC by a transaction hash
+func (client *Client) getMsgTx(txid string) (*wire.MsgTx, error) {
+	tx, err := client.GetTransaction(txid, false)
+	if err != nil {
+		return nil, err
+	}
+	return deserializeTransaction(tx.Hex)
+}
+
+// isCoinbaseTx returns whether a funding transaction is coinbase, the result is cached by the utxo manager
+func (client *Client) isCoinbaseTx(txid string) (bool, error) {
+	txHash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return false, err
+	}
+	if isCoinbase, ok := client.utxoManager.isCoinbase(*txHash); ok {
+		return isCoinbase, nil
+	}
+	tx, err := client.getMsgTx(txid)
+	if err != nil {
+		return false, err
 	}
-	messageIndex := markerIndex + utfMarkerLength
+	isCoinbase := blockchain.IsCoinBaseTx(tx)
+	client.utxoManager.setCoinbase(*txHash, isCoinbase)
+	return isCoinbase, nil
+}
 
-	messageHex := inscriptionHex[messageIndex : len(inscriptionHex)-2]
-	decodedBytes, err := hex.DecodeString(messageHex)
+// deserializeTransaction decodes a raw transaction hex
+func deserializeTransaction(txHex string) (*wire.MsgTx, error) {
+	txBytes, err := hex.DecodeString(txHex)
+	if err != nil {
+		return nil, err
+	}
+	var tx wire.MsgTx
+	err = tx.Deserialize(bytes.NewReader(txBytes))
 	if err != nil {
 		return nil, err
 	}
-	return decodedBytes, nil
+	return &tx, nil
 }
 
-// GetBlockchainHeight returns the current height of the btc blockchain
+// GetBlockchainHeight returns the current height of the btc blockchain,
+// the tip header is verified against the header chain if a header checkpoint is configured
 func (client *Client) GetBlockchainHeight() (int32, error) {
 	blockChainInfo, err := client.IndexerClient.GetBlockchainInfo(context.Background())
 	if err != nil {
 		return -1, err
 	}
+	if client.headerStore != nil {
+		if err := client.headerStore.VerifyTip(blockChainInfo.Height, blockChainInfo.Hex); err != nil {
+			return -1, err
+		}
+	}
 	return blockChainInfo.Height, nil
 }
 
-// listUnspent returns a list of unsent utxos filtered by address
+// listUnspent returns a list of unsent utxos of all the output scripts spendable by the keychain
 func (client *Client) ListUnspent() ([]*indexer.UTXO, error) {
-	indexerResponse, err := client.IndexerClient.ListUnspent(context.Background(), client.keychain.GetPublicKey())
-	if err != nil {
-		return nil, err
-	}
-	blockchainHeight, err := client.GetBlockchainHeight()
+	walletUtxos, blockchainHeight, err := client.listWalletUTXOs()
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO change and move to config when no longer using coinbase transactions for testing
-	requiredCoinbaseConfirmations := int32(100)
 	utxos := []*indexer.UTXO{}
-	for _, r := range indexerResponse {
-		// blockchain height - transacton block height + 1 in order to count the block of the transaction
-		confirmations := blockchainHeight - int32(r.Height) + 1
-		if confirmations > requiredCoinbaseConfirmations {
+	for _, r := range walletUtxos {
+		isMature, err := client.isMature(r, blockchainHeight)
+		if err != nil {
+			return nil, err
+		}
+		if isMature {
 			utxos = append(utxos, r)
-
 		}
 	}
 
 	return utxos, nil
 }
 
+// listWalletUTXOs returns the utxos of all the output scripts spendable by the keychain, whatever their confirmations,
+// and the blockchain height they were listed at
+func (client *Client) listWalletUTXOs() ([]*indexer.UTXO, int32, error) {
+	utxos := []*indexer.UTXO{}
+	for _, pkScript := range client.keychain.GetPkScripts() {
+		scriptUtxos, err := client.IndexerClient.ListUnspent(context.Background(), pkScript)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, utxo := range scriptUtxos {
+			utxo.PkScript = pkScript
+		}
+		utxos = append(utxos, scriptUtxos...)
+	}
+	blockchainHeight, err := client.GetBlockchainHeight()
+	if err != nil {
+		return nil, 0, err
+	}
+	return utxos, blockchainHeight, nil
+}
+
+// isMature returns whether a utxo has the confirmations required by the confirmation policy,
+// the coinbase maturity applies only to the outputs of coinbase transactions
+func (client *Client) isMature(utxo *indexer.UTXO, blockchainHeight int32) (bool, error) {
+	confirmations := confirmationsAt(int32(utxo.Height), blockchainHeight)
+	if confirmations < client.confirmations.Spendable {
+		return false, nil
+	}
+	if confirmations < client.confirmations.Coinbase {
+		isCoinbase, err := client.isCoinbaseTx(utxo.TxHash)
+		if err != nil {
+			return false, err
+		}
+		return !isCoinbase, nil
+	}
+	return true, nil
+}
+
 // GetHistory returns the confirmed history of the scripthash, starting from the startHeight if > 0
 func (client *Client) GetHistory(startHeight int, includeMempool bool) ([]*indexer.Transaction, error) {
-	transactions, err := client.IndexerClient.GetHistory(context.Background(), client.keychain.GetPublicKey())
+	transactions, err := client.IndexerClient.GetHistory(context.Background(), client.keychain.GetPkScript())
 	if err != nil {
 		return nil, err
 	}
@@ -452,8 +862,14 @@ func getStartHeightIndex(transactions []*indexer.Transaction, targetHeight int)
 	return targetIndex
 }
 
+// rawTxOutput is an output of a raw transaction paying an address
+type rawTxOutput struct {
+	address btcutil.Address
+	amount  btcutil.Amount
+}
+
 // createRawTransaction returns an unsigned transaction
-func (client *Client) createRawTransaction(inputs []btcjson.TransactionInput, outputAmount *btcutil.Amount, outputAddress *btcutil.Address) (*wire.MsgTx, error) {
+func (client *Client) createRawTransaction(inputs []btcjson.TransactionInput, outputs ...rawTxOutput) (*wire.MsgTx, error) {
 	tx := wire.NewMsgTx(wire.TxVersion)
 
 	for _, input := range inputs {
@@ -466,33 +882,30 @@ func (client *Client) createRawTransaction(inputs []btcjson.TransactionInput, ou
 		txIn := wire.NewTxIn(wire.NewOutPoint(hash, uint32(outputIndex)), nil, nil)
 		tx.AddTxIn(txIn)
 	}
-	pubKeyHash := (*outputAddress).ScriptAddress()
-	witnessProgram := append([]byte{0x00, 0x14}, pubKeyHash...)
+	for _, output := range outputs {
+		pkScript, err := txscript.PayToAddrScript(output.address)
+		if err != nil {
+			return nil, fmt.Errorf("error creating output script: %v", err)
+		}
 
-	txOut := wire.NewTxOut(int64(*outputAmount), witnessProgram)
-	tx.AddTxOut(txOut)
+		txOut := wire.NewTxOut(int64(output.amount), pkScript)
+		tx.AddTxOut(txOut)
+	}
 
 	return tx, nil
 }
 
-// GetBlockHeader returns a block header struct by a given block height
+// GetBlockHeader returns a block header struct by a given block height,
+// the header is verified against the header chain if a header checkpoint is configured
 func (client *Client) GetBlockHeader(height uint64) (*wire.BlockHeader, error) {
-	blockHeaderHex, err := client.IndexerClient.GetBlockHeader(context.Background(), height)
-	if err != nil {
-		return nil, err
+	if client.headerStore != nil {
+		return client.headerStore.Header(int32(height))
 	}
 
-	data, err := hex.DecodeString(blockHeaderHex)
-	if err != nil {
-		return nil, err
-	}
-
-	var blockHeader wire.BlockHeader
-	reader := bytes.NewReader(data)
-	err = blockHeader.Deserialize(reader)
+	blockHeaderHex, err := client.IndexerClient.GetBlockHeader(context.Background(), height)
 	if err != nil {
 		return nil, err
 	}
 
-	return &blockHeader, nil
+	return deserializeBlockHeader(blockHeaderHex)
 }
@@ -1,14 +1,24 @@
 package btcman
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"testing"
 
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/grail-rollup/btcman/indexer"
 	"github.com/grail-rollup/btcman/mocks"
 	"github.com/ledgerwatch/log/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewClient(t *testing.T) {
@@ -182,3 +192,273 @@ func TestGetBlockHeader(t *testing.T) {
 
 	assert.Equal(t, bh2.PrevBlock, bh1.BlockHash())
 }
+
+// newTestWriterClient returns a writer client funded by confirmed utxos of the given values in sats
+func newTestWriterClient(t *testing.T, mockIndexer *mocks.Indexer, values ...int64) *Client {
+	k, err := NewKeychain(&Config{PrivateKey: testPrivateKey}, WriterMode, P2WPKHAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	require.NoError(t, err)
+	return newTestKeychainClient(t, mockIndexer, k, values...)
+}
+
+// newTestKeychainClient returns a client of the keychain whose wallet script has utxos of the values
+func newTestKeychainClient(t *testing.T, mockIndexer *mocks.Indexer, k Keychainer, values ...int64) *Client {
+	address := k.GetAddress()
+
+	utxos := []*indexer.UTXO{}
+	for i, value := range values {
+		fundingHash := chainhash.HashH([]byte(fmt.Sprintf("funding %d", i)))
+		utxos = append(utxos, &indexer.UTXO{TxHash: fundingHash.String(), TxPos: 0, Value: value, Height: 1})
+		mockIndexer.On("GetTransaction", mock.Anything, fundingHash.String(), true).Return(&btcjson.TxRawResult{
+			Vout: []btcjson.Vout{{
+				Value:        btcutil.Amount(value).ToBTC(),
+				ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(k.GetPkScript())},
+			}},
+		}, nil)
+	}
+	mockIndexer.On("ListUnspent", mock.Anything, k.GetPkScript()).Return(utxos, nil)
+	mockIndexer.On("ListUnspent", mock.Anything, mock.Anything).Return([]*indexer.UTXO{}, nil)
+	mockIndexer.On("GetBlockchainInfo", mock.Anything).Return(&indexer.BlockChainInfo{Height: 1000}, nil)
+
+	return &Client{
+		logger:        log.New("testing"),
+		keychain:      k,
+		netParams:     &chaincfg.RegressionNetParams,
+		address:       &address,
+		IndexerClient: mockIndexer,
+		utxoManager:   newUtxoManager(false),
+		coinSelector:  branchAndBoundSelector{},
+		utxoThreshold: 10000,
+	}
+}
+
+func TestInscribeBatch(t *testing.T) {
+	payloads := [][]byte{[]byte("batch 1"), []byte("batch 2"), []byte("batch 3")}
+
+	for _, singleReveal := range []bool{true, false} {
+		t.Run(fmt.Sprintf("single reveal %t", singleReveal), func(t *testing.T) {
+			mockIndexer := new(mocks.Indexer)
+			btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+			sent := []*wire.MsgTx{}
+			mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return(func(tx *wire.MsgTx) string {
+				sent = append(sent, tx)
+				return tx.TxHash().String()
+			}, nil)
+
+			result, err := btcman.InscribeBatch(payloads, InscribeOptions{SingleReveal: singleReveal})
+			require.NoError(t, err)
+			require.Len(t, result.Items, len(payloads))
+
+			commitTx := sent[0]
+			assert.Equal(t, commitTx.TxHash(), *result.CommitTxHash)
+			if singleReveal {
+				require.Len(t, sent, 2)
+			} else {
+				require.Len(t, sent, 1+len(payloads))
+			}
+
+			for i, item := range result.Items {
+				require.NoError(t, item.Err)
+				assert.Positive(t, item.RevealFee)
+				id, err := NewInscriptionIDFromStr(item.InscriptionID)
+				require.NoError(t, err)
+				assert.Equal(t, *item.RevealTxHash, id.TxHash)
+
+				revealTx := sent[1]
+				if !singleReveal {
+					revealTx = sent[1+i]
+				}
+				inscriptions := ParseInscriptions(revealTx)
+				require.Greater(t, len(inscriptions), int(id.Index))
+				assert.Equal(t, payloads[i], inscriptions[id.Index].Body)
+				assert.Equal(t, commitTx.TxHash(), revealTx.TxIn[inscriptions[id.Index].InputIndex].PreviousOutPoint.Hash)
+			}
+		})
+	}
+}
+
+func TestInscribeBatchRevealFailure(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+	sent := 0
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return(func(tx *wire.MsgTx) string {
+		sent++
+		return tx.TxHash().String()
+	}, nil).Twice()
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return("", errors.New("rejected"))
+
+	result, err := btcman.InscribeBatch([][]byte{[]byte("a"), []byte("b")}, InscribeOptions{})
+	assert.Error(t, err)
+	require.NotNil(t, result)
+	assert.NoError(t, result.Items[0].Err)
+	assert.NotEmpty(t, result.Items[0].InscriptionID)
+	assert.Error(t, result.Items[1].Err)
+}
+
+func TestEstimateInscription(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+
+	estimate, err := btcman.EstimateInscription([]byte("batch"), InscribeOptions{})
+	require.NoError(t, err)
+	require.Len(t, estimate.RevealTxs, 1)
+	require.Len(t, estimate.Inputs, 1)
+	assert.EqualValues(t, 1_000_000, estimate.InputValue)
+	assert.Positive(t, estimate.Change)
+	assert.Positive(t, estimate.CommitTx.Fee)
+	assert.Positive(t, estimate.RevealTxs[0].Fee)
+	assert.Equal(t, estimate.CommitTx.Fee+estimate.RevealTxs[0].Fee, estimate.Fees)
+	assert.Less(t, estimate.CommitTx.VSize, estimate.CommitTx.Weight)
+
+	// nothing is sent and the utxo isn't leased by the estimate
+	result, err := btcman.InscribeWithOptions([]byte("batch"), InscribeOptions{DryRun: true})
+	require.NoError(t, err)
+	mockIndexer.AssertNotCalled(t, "SendTransaction", mock.Anything, mock.Anything)
+	assert.True(t, result.Signed)
+
+	commitTx, err := deserializeTransaction(result.CommitTxHex)
+	require.NoError(t, err)
+	assert.Equal(t, commitTx.TxHash(), *result.CommitTxHash)
+	require.Len(t, result.RevealTxHexList, 1)
+	revealTx, err := deserializeTransaction(result.RevealTxHexList[0])
+	require.NoError(t, err)
+	assert.Equal(t, revealTx.TxHash(), *result.RevealTxHashList[0])
+	assert.Equal(t, commitTx.TxHash(), revealTx.TxIn[0].PreviousOutPoint.Hash)
+	assert.Equal(t, []byte("batch"), ParseInscriptions(revealTx)[0].Body)
+	assert.NotEmpty(t, commitTx.TxIn[0].Witness)
+}
+
+func TestEstimateInscriptionRemoteCosigners(t *testing.T) {
+	cfg, privateKeys := newMultisigTestConfig(t, 2)
+	// the cosigner fails if reached, the estimate and the dry run are signed with the witness templates
+	k, err := NewMultisigKeychain(cfg, WriterMode, P2TRAddress, &chaincfg.RegressionNetParams, log.New("testing"),
+		&failingCosigner{publicKey: privateKeys[1].PubKey()})
+	require.NoError(t, err)
+
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestKeychainClient(t, mockIndexer, k, 1_000_000)
+
+	estimate, err := btcman.EstimateInscription([]byte("multisig"), InscribeOptions{})
+	require.NoError(t, err)
+	require.Len(t, estimate.Inputs, 1)
+
+	result, err := btcman.InscribeWithOptions([]byte("multisig"), InscribeOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.False(t, result.Signed)
+	commitTx, err := deserializeTransaction(result.CommitTxHex)
+	require.NoError(t, err)
+	template, _ := k.(witnessTemplater).witnessTemplate(k.GetPkScript())
+	assert.Equal(t, template.SerializeSize(), commitTx.TxIn[0].Witness.SerializeSize())
+	assert.Equal(t, estimate.CommitTx.VSize, mempool.GetTxVirtualSize(btcutil.NewTx(commitTx)))
+
+	// the transactions that are sent reach the cosigner
+	_, err = btcman.InscribeWithOptions([]byte("multisig"), InscribeOptions{})
+	assert.Error(t, err)
+}
+
+func TestCommitFeeRateMultipleInputs(t *testing.T) {
+	p2pkh, err := NewKeychain(&Config{PrivateKey: testPrivateKey}, WriterMode, P2PKHAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	require.NoError(t, err)
+	cfg, privateKeys := newMultisigTestConfig(t, 2)
+	p2wsh, err := NewMultisigKeychain(cfg, WriterMode, P2WSHAddress, &chaincfg.RegressionNetParams, log.New("testing"),
+		NewLocalCosigner(privateKeys[1]))
+	require.NoError(t, err)
+
+	for name, k := range map[string]Keychainer{"p2pkh": p2pkh, "p2wsh": p2wsh} {
+		t.Run(name, func(t *testing.T) {
+			// the commit spends several utxos, every input pays for its signed size
+			values := make([]int64, 10)
+			for i := range values {
+				values[i] = 20_000
+			}
+			mockIndexer := new(mocks.Indexer)
+			btcman := newTestKeychainClient(t, mockIndexer, k, values...)
+			btcman.utxoThreshold = 0
+
+			tool, err := btcman.inscriptionTool(make([]byte, 150_000), InscribeOptions{})
+			require.NoError(t, err)
+			require.Greater(t, len(tool.commitTx.TxIn), 1)
+
+			estimate := tool.Estimate()
+			assert.GreaterOrEqual(t, estimate.CommitTx.Fee, estimate.CommitTx.VSize*inscriptionCommitFeeRate)
+		})
+	}
+}
+
+func TestInscribeUtxoThreshold(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 9_000, 9_500, 200_000)
+
+	// the utxos below the threshold are left to the consolidation
+	estimate, err := btcman.EstimateInscription([]byte("threshold"), InscribeOptions{})
+	require.NoError(t, err)
+	require.Len(t, estimate.Inputs, 1)
+	assert.EqualValues(t, 200_000, estimate.InputValue)
+
+	btcman.utxoThreshold = 300_000
+	_, err = btcman.EstimateInscription([]byte("threshold"), InscribeOptions{})
+	assert.ErrorContains(t, err, "no UTXOs above the threshold")
+}
+
+func TestRevealOutputPolicy(t *testing.T) {
+	holdingKey, err := NewKeychain(&Config{PrivateKey: testPrivateKey}, WriterMode, P2TRAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	require.NoError(t, err)
+	holdingAddress := holdingKey.GetAddress().String()
+
+	t.Run("dust safe default", func(t *testing.T) {
+		mockIndexer := new(mocks.Indexer)
+		btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+
+		result, err := btcman.InscribeWithOptions([]byte("policy"), InscribeOptions{DryRun: true})
+		require.NoError(t, err)
+		revealTx, err := deserializeTransaction(result.RevealTxHexList[0])
+		require.NoError(t, err)
+		require.Len(t, revealTx.TxOut, 1)
+		assert.EqualValues(t, 294, revealTx.TxOut[0].Value)
+		assert.Equal(t, btcman.keychain.GetPkScript(), revealTx.TxOut[0].PkScript)
+	})
+
+	t.Run("holding address and tag", func(t *testing.T) {
+		mockIndexer := new(mocks.Indexer)
+		btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+		btcman.cfg.InscriptionHoldingAddress = holdingAddress
+
+		result, err := btcman.InscribeWithOptions([]byte("policy"), InscribeOptions{DryRun: true, RevealTag: []byte("tag")})
+		require.NoError(t, err)
+		revealTx, err := deserializeTransaction(result.RevealTxHexList[0])
+		require.NoError(t, err)
+		require.Len(t, revealTx.TxOut, 2)
+		assert.EqualValues(t, 330, revealTx.TxOut[0].Value)
+		assert.Equal(t, holdingKey.GetPkScript(), revealTx.TxOut[0].PkScript)
+		assert.Equal(t, txscript.NullDataTy, txscript.GetScriptClass(revealTx.TxOut[1].PkScript))
+		assert.Zero(t, revealTx.TxOut[1].Value)
+	})
+
+	t.Run("destination and value of the options", func(t *testing.T) {
+		mockIndexer := new(mocks.Indexer)
+		btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+		btcman.cfg.RevealOutValue = 600
+
+		result, err := btcman.InscribeWithOptions([]byte("policy"), InscribeOptions{DryRun: true, Destination: holdingAddress, RevealOutValue: 1000})
+		require.NoError(t, err)
+		revealTx, err := deserializeTransaction(result.RevealTxHexList[0])
+		require.NoError(t, err)
+		assert.EqualValues(t, 1000, revealTx.TxOut[0].Value)
+		assert.Equal(t, holdingKey.GetPkScript(), revealTx.TxOut[0].PkScript)
+	})
+
+	t.Run("dust value", func(t *testing.T) {
+		mockIndexer := new(mocks.Indexer)
+		btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+
+		_, err := btcman.InscribeWithOptions([]byte("policy"), InscribeOptions{DryRun: true, RevealOutValue: 100})
+		assert.Error(t, err)
+	})
+
+	t.Run("oversized tag", func(t *testing.T) {
+		mockIndexer := new(mocks.Indexer)
+		btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+
+		_, err := btcman.InscribeWithOptions([]byte("policy"), InscribeOptions{DryRun: true, RevealTag: make([]byte, MaxStandardOpReturnSize+1)})
+		assert.Error(t, err)
+	})
+}
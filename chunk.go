@@ -0,0 +1,185 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+// DefaultChunkSize is the maximum body size of a chunk, it keeps every reveal transaction below MaxStandardTxWeight
+const DefaultChunkSize = 380000
+
+// ChunkMetadata is the metadata of an inscription carrying a chunk of a payload split across several reveal transactions
+type ChunkMetadata struct {
+	// Sequence is the position of the chunk in the payload
+	Sequence uint32 `cbor:"seq"`
+	// Total is the number of chunks of the payload
+	Total uint32 `cbor:"total"`
+	// Digest is the sha256 of the whole inscribed payload, i.e. of all the chunk bodies concatenated
+	Digest []byte `cbor:"digest"`
+	// Size is the size of the whole inscribed payload
+	Size uint64 `cbor:"size"`
+	// ContentEncoding is the encoding of the whole payload, the chunks carry no content encoding tag
+	ContentEncoding string `cbor:"encoding,omitempty"`
+}
+
+// chunkedInscriptionData splits the body into chunks and returns the inscription data of every chunk in order
+func (opts InscribeOptions) chunkedInscriptionData(body []byte, destination string) ([]InscriptionData, error) {
+	if opts.Metadata != nil {
+		return nil, errors.New("metadata can't be set on a chunked inscription")
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize == 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if chunkSize < 0 || chunkSize > DefaultChunkSize {
+		return nil, fmt.Errorf("chunk size must be between 1 and %d", DefaultChunkSize)
+	}
+
+	body, contentEncoding, err := opts.encodeBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(body)
+	total := (len(body) + chunkSize - 1) / chunkSize
+	if total == 0 {
+		total = 1
+	}
+
+	dataList := make([]InscriptionData, total)
+	for sequence := 0; sequence < total; sequence++ {
+		start := sequence * chunkSize
+		end := start + chunkSize
+		if end > len(body) {
+			end = len(body)
+		}
+
+		chunkOpts := opts
+		chunkOpts.ContentEncoding = ""
+		chunkOpts.Compression = NoCompression
+		chunkOpts.Metadata = ChunkMetadata{
+			Sequence:        uint32(sequence),
+			Total:           uint32(total),
+			Digest:          digest[:],
+			Size:            uint64(len(body)),
+			ContentEncoding: contentEncoding,
+		}
+		data, err := chunkOpts.inscriptionData(body[start:end], destination)
+		if err != nil {
+			return nil, err
+		}
+		dataList[sequence] = data
+	}
+	return dataList, nil
+}
+
+// ChunkMetadata returns the chunk metadata of an inscription created in chunked mode
+func (inscription *Inscription) ChunkMetadata() (*ChunkMetadata, error) {
+	metadata := &ChunkMetadata{}
+	if err := inscription.DecodeMetadata(metadata); err != nil {
+		return nil, fmt.Errorf("inscription is not a chunk: %v", err)
+	}
+	if metadata.Total == 0 || metadata.Sequence >= metadata.Total || len(metadata.Digest) != sha256.Size {
+		return nil, errors.New("inscription has invalid chunk metadata")
+	}
+	return metadata, nil
+}
+
+// DecodeChunkedInscription fetches all the chunks of a payload inscribed in chunked mode starting from the reveal
+// transaction of the first chunk, verifies them against the payload commitment and returns the decoded payload
+func (client *Client) DecodeChunkedInscription(firstRevealTxHash string) ([]byte, error) {
+	revealTx, err := client.getMsgTx(firstRevealTxHash)
+	if err != nil {
+		return nil, err
+	}
+	first, metadata, err := chunkOfTx(revealTx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Sequence != 0 {
+		return nil, fmt.Errorf("transaction %s reveals chunk %d, not the first chunk", firstRevealTxHash, metadata.Sequence)
+	}
+
+	// the chunks are revealed from consecutive outputs of the same commit transaction
+	firstOutPoint := revealTx.TxIn[first.InputIndex].PreviousOutPoint
+	commitTx, err := client.getMsgTx(firstOutPoint.Hash.String())
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([][]byte, metadata.Total)
+	chunks[0] = first.Body
+	for sequence := uint32(1); sequence < metadata.Total; sequence++ {
+		outPoint := wire.OutPoint{Hash: firstOutPoint.Hash, Index: firstOutPoint.Index + sequence}
+		if int(outPoint.Index) >= len(commitTx.TxOut) {
+			return nil, fmt.Errorf("commit transaction %s has no output for chunk %d", outPoint.Hash, sequence)
+		}
+		spendingTx, _, err := client.findSpendingTx(outPoint, commitTx.TxOut[outPoint.Index].PkScript)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %v", sequence, err)
+		}
+		chunk, chunkMetadata, err := chunkOfTx(spendingTx, &outPoint)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %v", sequence, err)
+		}
+		if chunkMetadata.Sequence != sequence || chunkMetadata.Total != metadata.Total ||
+			!bytes.Equal(chunkMetadata.Digest, metadata.Digest) {
+			return nil, fmt.Errorf("chunk %d doesn't belong to the payload", sequence)
+		}
+		chunks[sequence] = chunk.Body
+	}
+
+	payload := bytes.Join(chunks, nil)
+	digest := sha256.Sum256(payload)
+	if uint64(len(payload)) != metadata.Size || !bytes.Equal(digest[:], metadata.Digest) {
+		return nil, errors.New("chunked payload doesn't match its commitment")
+	}
+	return decompressBody(payload, metadata.ContentEncoding)
+}
+
+// chunkOfTx returns the first chunk inscription of a transaction, revealed by the input spending outPoint if not nil
+func chunkOfTx(tx *wire.MsgTx, outPoint *wire.OutPoint) (*Inscription, *ChunkMetadata, error) {
+	for _, inscription := range ParseInscriptions(tx) {
+		if outPoint != nil && tx.TxIn[inscription.InputIndex].PreviousOutPoint != *outPoint {
+			continue
+		}
+		metadata, err := inscription.ChunkMetadata()
+		if err != nil {
+			continue
+		}
+		return inscription, metadata, nil
+	}
+	return nil, nil, fmt.Errorf("transaction %s has no chunk inscription", tx.TxHash())
+}
+
+// errOutputNotSpent is returned by findSpendingTx when no transaction of the script history spends the outpoint
+var errOutputNotSpent = errors.New("output is not spent")
+
+// findSpendingTx looks up the transaction spending an outpoint in the history of its output script
+// and returns it with its block height, 0 or less if unconfirmed
+func (client *Client) findSpendingTx(outPoint wire.OutPoint, pkScript []byte) (*wire.MsgTx, int32, error) {
+	history, err := client.IndexerClient.GetHistory(context.Background(), pkScript)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, transaction := range history {
+		if transaction.TxHash == outPoint.Hash.String() {
+			continue
+		}
+		tx, err := client.getMsgTx(transaction.TxHash)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, txIn := range tx.TxIn {
+			if txIn.PreviousOutPoint == outPoint {
+				return tx, transaction.Height, nil
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: %s", errOutputNotSpent, outPoint)
+}
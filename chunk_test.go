@@ -0,0 +1,116 @@
+package btcman
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newChunkedTestTxs returns a commit transaction and the reveal transactions of the chunks
+func newChunkedTestTxs(t *testing.T, dataList []InscriptionData) (*wire.MsgTx, []*wire.MsgTx) {
+	commitTx := wire.NewMsgTx(wire.TxVersion)
+	commitTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 7}, nil, wire.TxWitness{make([]byte, 64)}))
+	txCtxDataList := make([]*inscriptionTxCtxData, len(dataList))
+	for i, data := range dataList {
+		txCtxData, err := createInscriptionTxCtxData(&chaincfg.RegressionNetParams, data)
+		require.NoError(t, err)
+		txCtxDataList[i] = txCtxData
+		commitTx.AddTxOut(wire.NewTxOut(10000, txCtxData.commitTxAddressPkScript))
+	}
+
+	revealTxs := make([]*wire.MsgTx, len(dataList))
+	for i, txCtxData := range txCtxDataList {
+		witness := wire.TxWitness{make([]byte, 64), txCtxData.inscriptionScript, txCtxData.controlBlockWitness}
+		revealTx := wire.NewMsgTx(wire.TxVersion)
+		revealTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: commitTx.TxHash(), Index: uint32(i)}, nil, witness))
+		revealTx.AddTxOut(wire.NewTxOut(1000, txCtxData.commitTxAddressPkScript))
+		revealTxs[i] = revealTx
+	}
+	return commitTx, revealTxs
+}
+
+func mockTransaction(t *testing.T, mockIndexer *mocks.Indexer, tx *wire.MsgTx) {
+	txHex, err := indexer.GetTxHex(tx)
+	require.NoError(t, err)
+	mockIndexer.On("GetTransaction", mock.Anything, tx.TxHash().String(), false).Return(&btcjson.TxRawResult{Hex: txHex}, nil)
+}
+
+func TestChunkedInscription(t *testing.T) {
+	random := make([]byte, 300)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+	payload := []byte(hex.EncodeToString(random))
+	opts := InscribeOptions{Chunked: true, ChunkSize: 100, Compression: GzipCompression}
+	dataList, err := opts.chunkedInscriptionData(payload, "")
+	require.NoError(t, err)
+	require.Greater(t, len(dataList), 1)
+	for _, data := range dataList {
+		assert.Empty(t, data.ContentEncoding)
+		assert.LessOrEqual(t, len(data.Body), 100)
+	}
+
+	commitTx, revealTxs := newChunkedTestTxs(t, dataList)
+	mockIndexer := new(mocks.Indexer)
+	mockTransaction(t, mockIndexer, commitTx)
+	for i, revealTx := range revealTxs {
+		mockTransaction(t, mockIndexer, revealTx)
+		mockIndexer.On("GetHistory", mock.Anything, commitTx.TxOut[i].PkScript).Return([]*indexer.Transaction{
+			{TxHash: commitTx.TxHash().String(), Height: 100},
+			{TxHash: revealTx.TxHash().String(), Height: 100},
+		}, nil)
+	}
+	btcman := &Client{IndexerClient: mockIndexer}
+
+	decoded, err := btcman.DecodeChunkedInscription(revealTxs[0].TxHash().String())
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	_, err = btcman.DecodeChunkedInscription(revealTxs[1].TxHash().String())
+	assert.Error(t, err)
+}
+
+func TestChunkedInscriptionTampered(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xab}, 250)
+	dataList, err := InscribeOptions{Chunked: true, ChunkSize: 100}.chunkedInscriptionData(payload, "")
+	require.NoError(t, err)
+	require.Len(t, dataList, 3)
+	dataList[2].Body = []byte{0xcd}
+
+	commitTx, revealTxs := newChunkedTestTxs(t, dataList)
+	mockIndexer := new(mocks.Indexer)
+	mockTransaction(t, mockIndexer, commitTx)
+	for i, revealTx := range revealTxs {
+		mockTransaction(t, mockIndexer, revealTx)
+		mockIndexer.On("GetHistory", mock.Anything, commitTx.TxOut[i].PkScript).Return([]*indexer.Transaction{
+			{TxHash: revealTx.TxHash().String(), Height: 100},
+		}, nil)
+	}
+	btcman := &Client{IndexerClient: mockIndexer}
+
+	_, err = btcman.DecodeChunkedInscription(revealTxs[0].TxHash().String())
+	assert.ErrorContains(t, err, "commitment")
+}
+
+func TestChunkRevealWeight(t *testing.T) {
+	payload := make([]byte, DefaultChunkSize+1)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+	dataList, err := InscribeOptions{Chunked: true}.chunkedInscriptionData(payload, "")
+	require.NoError(t, err)
+	require.Len(t, dataList, 2)
+
+	_, revealTxs := newChunkedTestTxs(t, dataList[:1])
+	assert.Less(t, blockchain.GetTransactionWeight(btcutil.NewTx(revealTxs[0])), int64(MaxStandardTxWeight))
+}
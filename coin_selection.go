@@ -0,0 +1,273 @@
+package btcman
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+)
+
+// CoinSelectionStrategy is the name of a coin selection algorithm
+type CoinSelectionStrategy string
+
+const (
+	// BranchAndBound looks for a changeless selection and falls back to LargestFirst
+	BranchAndBound CoinSelectionStrategy = "branch-and-bound"
+	// LargestFirst spends the largest utxos until the target is reached
+	LargestFirst CoinSelectionStrategy = "largest-first"
+	// SmallestSufficient spends the smallest single utxo covering the target and falls back to LargestFirst
+	SmallestSufficient CoinSelectionStrategy = "smallest-sufficient"
+	// PrivacyAware avoids linking different output scripts in one transaction and falls back to LargestFirst
+	PrivacyAware CoinSelectionStrategy = "privacy-aware"
+)
+
+const (
+	// bnbMaxTries is the maximum number of branch and bound search steps
+	bnbMaxTries = 100000
+	// maxSelectionAttempts is the number of selections tried when the selected utxos get leased concurrently
+	maxSelectionAttempts = 3
+)
+
+// ErrInsufficientFunds is returned when the utxos can't cover the selection target
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// CoinSelectionTarget is the amount a coin selection must cover
+type CoinSelectionTarget struct {
+	// Amount is the value of the outputs plus the fee of the transaction without inputs and change, in satoshi
+	Amount int64
+	// FeeRate is the fee rate in sat/vbyte, every selected utxo pays the fee of its input
+	FeeRate int64
+	// ChangeCost is the fee of a change output, a changeless selection may exceed the amount by up to it
+	ChangeCost int64
+	// InputVSize returns the virtual size of a signed input spending pkScript, defaults to the size by the script class
+	InputVSize func(pkScript []byte) int64
+}
+
+// inputVSize returns the virtual size of a signed input spending pkScript by the sizing of the target
+func (target CoinSelectionTarget) inputVSize(pkScript []byte) int64 {
+	if target.InputVSize != nil {
+		return target.InputVSize(pkScript)
+	}
+	return inputVSize(pkScript)
+}
+
+// CoinSelector selects the utxos funding a transaction
+type CoinSelector interface {
+	Select(utxos []*indexer.UTXO, target CoinSelectionTarget) ([]*indexer.UTXO, error)
+}
+
+// NewCoinSelector returns the coin selector of a strategy
+func NewCoinSelector(strategy CoinSelectionStrategy) (CoinSelector, error) {
+	switch strategy {
+	case BranchAndBound:
+		return branchAndBoundSelector{}, nil
+	case LargestFirst:
+		return largestFirstSelector{}, nil
+	case SmallestSufficient:
+		return smallestSufficientSelector{}, nil
+	case PrivacyAware:
+		return privacyAwareSelector{}, nil
+	default:
+		return nil, fmt.Errorf("invalid coin selection strategy %s", strategy)
+	}
+}
+
+// candidate is a utxo with the value left after paying for its input
+type candidate struct {
+	utxo           *indexer.UTXO
+	effectiveValue int64
+}
+
+// candidates returns the utxos worth spending at the target fee rate, sorted by descending effective value
+func candidates(utxos []*indexer.UTXO, target CoinSelectionTarget) []candidate {
+	result := []candidate{}
+	for _, utxo := range utxos {
+		effectiveValue := utxo.Value - target.inputVSize(utxo.PkScript)*target.FeeRate
+		if effectiveValue > 0 {
+			result = append(result, candidate{utxo: utxo, effectiveValue: effectiveValue})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].effectiveValue > result[j].effectiveValue
+	})
+	return result
+}
+
+// witnessTemplater is implemented by the keychains whose signed inputs don't have the size of their script class,
+// e.g. the multisig scripts. The template witness has the size of a signed input witness
+type witnessTemplater interface {
+	witnessTemplate(pkScript []byte) (wire.TxWitness, bool)
+}
+
+// inputVSize returns the virtual size of a signed input of the wallet spending pkScript,
+// sized by the witness template of the keychain if it has one
+func (client *Client) inputVSize(pkScript []byte) int64 {
+	return keychainInputVSize(client.keychain, pkScript)
+}
+
+// keychainInputVSize returns the virtual size of an input spending pkScript signed by the keychain
+func keychainInputVSize(keychain Keychainer, pkScript []byte) int64 {
+	if templater, ok := keychain.(witnessTemplater); ok {
+		if witness, ok := templater.witnessTemplate(pkScript); ok {
+			return witnessInputVSize(witness)
+		}
+	}
+	return inputVSize(pkScript)
+}
+
+// witnessInputVSize is the virtual size of a segwit input with an empty signature script and the witness
+func witnessInputVSize(witness wire.TxWitness) int64 {
+	// an outpoint, an empty script and a sequence
+	const baseInputSize = 41
+	return baseInputSize + (int64(witness.SerializeSize())+blockchain.WitnessScaleFactor-1)/blockchain.WitnessScaleFactor
+}
+
+// inputVSize is the estimated virtual size of an input spending pkScript
+func inputVSize(pkScript []byte) int64 {
+	switch txscript.GetScriptClass(pkScript) {
+	case txscript.PubKeyHashTy:
+		return 148
+	case txscript.ScriptHashTy:
+		return 91
+	case txscript.WitnessV0PubKeyHashTy:
+		return 68
+	case txscript.WitnessV1TaprootTy:
+		return 58
+	case txscript.WitnessV0ScriptHashTy:
+		return 105
+	default:
+		// utxos listed without their script are wallet outputs of the default address type
+		if len(pkScript) == 0 {
+			return 68
+		}
+		return 148
+	}
+}
+
+// utxosOf returns the utxos of the candidates
+func utxosOf(selected []candidate) []*indexer.UTXO {
+	utxos := make([]*indexer.UTXO, len(selected))
+	for i := range selected {
+		utxos[i] = selected[i].utxo
+	}
+	return utxos
+}
+
+type largestFirstSelector struct{}
+
+// Select spends the largest utxos until the target amount is covered
+func (largestFirstSelector) Select(utxos []*indexer.UTXO, target CoinSelectionTarget) ([]*indexer.UTXO, error) {
+	return selectLargestFirst(candidates(utxos, target), target)
+}
+
+func selectLargestFirst(sorted []candidate, target CoinSelectionTarget) ([]*indexer.UTXO, error) {
+	total := int64(0)
+	for i := range sorted {
+		total += sorted[i].effectiveValue
+		if total >= target.Amount {
+			return utxosOf(sorted[:i+1]), nil
+		}
+	}
+	return nil, ErrInsufficientFunds
+}
+
+type smallestSufficientSelector struct{}
+
+// Select spends the smallest single utxo covering the target amount
+func (smallestSufficientSelector) Select(utxos []*indexer.UTXO, target CoinSelectionTarget) ([]*indexer.UTXO, error) {
+	sorted := candidates(utxos, target)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if sorted[i].effectiveValue >= target.Amount {
+			return []*indexer.UTXO{sorted[i].utxo}, nil
+		}
+	}
+	return selectLargestFirst(sorted, target)
+}
+
+type branchAndBoundSelector struct{}
+
+// Select searches the selection exceeding the target amount by less than the change cost with the lowest excess,
+// such a selection needs no change output. Without one it falls back to largest first.
+func (branchAndBoundSelector) Select(utxos []*indexer.UTXO, target CoinSelectionTarget) ([]*indexer.UTXO, error) {
+	sorted := candidates(utxos, target)
+
+	// remaining[i] is the effective value of sorted[i:], used to prune the branches that can't reach the target
+	remaining := make([]int64, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + sorted[i].effectiveValue
+	}
+	if remaining[0] < target.Amount {
+		return nil, ErrInsufficientFunds
+	}
+
+	tries := 0
+	selection := []candidate{}
+	var best []candidate
+	bestExcess := int64(0)
+	var search func(index int, value int64)
+	search = func(index int, value int64) {
+		if tries >= bnbMaxTries {
+			return
+		}
+		tries++
+		if value >= target.Amount {
+			// adding more utxos only increases the excess
+			if excess := value - target.Amount; excess <= target.ChangeCost && (best == nil || excess < bestExcess) {
+				best = append([]candidate{}, selection...)
+				bestExcess = excess
+			}
+			return
+		}
+		if index == len(sorted) || value+remaining[index] < target.Amount {
+			return
+		}
+		selection = append(selection, sorted[index])
+		search(index+1, value+sorted[index].effectiveValue)
+		selection = selection[:len(selection)-1]
+		search(index+1, value)
+	}
+	search(0, 0)
+
+	if best != nil {
+		return utxosOf(best), nil
+	}
+	return selectLargestFirst(sorted, target)
+}
+
+type privacyAwareSelector struct{}
+
+// Select funds the target from the utxos of a single output script, preferring the fewest inputs,
+// so the transaction doesn't link the different scripts of the wallet
+func (privacyAwareSelector) Select(utxos []*indexer.UTXO, target CoinSelectionTarget) ([]*indexer.UTXO, error) {
+	sorted := candidates(utxos, target)
+
+	groups := make(map[string][]candidate)
+	scripts := []string{}
+	for _, c := range sorted {
+		script := hex.EncodeToString(c.utxo.PkScript)
+		if _, ok := groups[script]; !ok {
+			scripts = append(scripts, script)
+		}
+		groups[script] = append(groups[script], c)
+	}
+
+	var best []*indexer.UTXO
+	for _, script := range scripts {
+		selection, err := smallestSufficientSelector{}.Select(utxosOf(groups[script]), target)
+		if err != nil {
+			continue
+		}
+		if best == nil || len(selection) < len(best) {
+			best = selection
+		}
+	}
+	if best != nil {
+		return best, nil
+	}
+	return selectLargestFirst(sorted, target)
+}
@@ -0,0 +1,97 @@
+package btcman
+
+import (
+	"testing"
+
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func utxoValues(utxos []*indexer.UTXO) []int64 {
+	values := []int64{}
+	for _, utxo := range utxos {
+		values = append(values, utxo.Value)
+	}
+	return values
+}
+
+func TestCoinSelectors(t *testing.T) {
+	p2wpkh := []byte{0x00, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14}
+	p2tr := append([]byte{0x51, 0x20}, make([]byte, 32)...)
+	newUtxo := func(value int64, pkScript []byte) *indexer.UTXO {
+		return &indexer.UTXO{TxHash: "00", Value: value, PkScript: pkScript}
+	}
+	// effective values at 1 sat/vbyte are the values minus 68 for p2wpkh and 58 for p2tr
+	utxos := []*indexer.UTXO{
+		newUtxo(30068, p2wpkh),
+		newUtxo(20068, p2wpkh),
+		newUtxo(10068, p2wpkh),
+		newUtxo(25058, p2tr),
+		newUtxo(100, p2wpkh),
+	}
+
+	tests := []struct {
+		name     string
+		strategy CoinSelectionStrategy
+		target   CoinSelectionTarget
+		expected []int64
+	}{
+		{
+			name:     "largest first",
+			strategy: LargestFirst,
+			target:   CoinSelectionTarget{Amount: 40000, FeeRate: 1},
+			expected: []int64{30068, 25058},
+		},
+		{
+			name:     "smallest sufficient",
+			strategy: SmallestSufficient,
+			target:   CoinSelectionTarget{Amount: 22000, FeeRate: 1},
+			expected: []int64{25058},
+		},
+		{
+			name:     "smallest sufficient fallback",
+			strategy: SmallestSufficient,
+			target:   CoinSelectionTarget{Amount: 50000, FeeRate: 1},
+			expected: []int64{30068, 25058},
+		},
+		{
+			name:     "branch and bound changeless",
+			strategy: BranchAndBound,
+			target:   CoinSelectionTarget{Amount: 40000, FeeRate: 1, ChangeCost: 100},
+			expected: []int64{30068, 10068},
+		},
+		{
+			name:     "branch and bound fallback",
+			strategy: BranchAndBound,
+			target:   CoinSelectionTarget{Amount: 40010, FeeRate: 1, ChangeCost: 5},
+			expected: []int64{30068, 25058},
+		},
+		{
+			name:     "privacy aware single script",
+			strategy: PrivacyAware,
+			target:   CoinSelectionTarget{Amount: 45000, FeeRate: 1},
+			expected: []int64{30068, 20068},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := NewCoinSelector(tt.strategy)
+			require.NoError(t, err)
+			selected, err := selector.Select(utxos, tt.target)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, utxoValues(selected))
+		})
+	}
+
+	for _, strategy := range []CoinSelectionStrategy{LargestFirst, SmallestSufficient, BranchAndBound, PrivacyAware} {
+		selector, err := NewCoinSelector(strategy)
+		require.NoError(t, err)
+		_, err = selector.Select(utxos, CoinSelectionTarget{Amount: 100000, FeeRate: 1})
+		assert.ErrorIs(t, err, ErrInsufficientFunds, strategy)
+	}
+
+	_, err := NewCoinSelector("random")
+	assert.Error(t, err)
+}
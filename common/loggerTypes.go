@@ -5,4 +5,7 @@ const (
 	INDEXER  = "btcman/indexer"
 	KEYCHAIN = "btcman/keychain"
 	TCP      = "btcman/indexer/lib_tcp"
+	QUEUE    = "btcman/queue"
+	SYNC     = "btcman/sync"
+	REORG    = "btcman/reorg"
 )
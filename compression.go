@@ -0,0 +1,106 @@
+package btcman
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the algorithm used for compressing inscription bodies, its value is the content encoding tag.
+// Ordinals explorers only recognize brotli, zstd and gzip bodies are only decoded by btcman.
+type Compression string
+
+const (
+	NoCompression     Compression = ""
+	BrotliCompression Compression = "br"
+	ZstdCompression   Compression = "zstd"
+	GzipCompression   Compression = "gzip"
+)
+
+// maxDecompressedBodySize is the limit of a decompressed body, protects the decoder against compression bombs
+const maxDecompressedBodySize = 64 << 20
+
+// compressBody compresses the body and returns it with its content encoding. The raw body and an
+// empty encoding are returned when the compressed body isn't smaller than the raw one.
+func compressBody(body []byte, compression Compression) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch compression {
+	case NoCompression:
+		return body, "", nil
+	case BrotliCompression:
+		writer := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+		if _, err := writer.Write(body); err != nil {
+			return nil, "", err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", err
+		}
+	case ZstdCompression:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+		if err != nil {
+			return nil, "", err
+		}
+		buf.Write(encoder.EncodeAll(body, nil))
+		if err := encoder.Close(); err != nil {
+			return nil, "", err
+		}
+	case GzipCompression:
+		writer, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := writer.Write(body); err != nil {
+			return nil, "", err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", err
+		}
+	default:
+		return nil, "", fmt.Errorf("unsupported compression %s", compression)
+	}
+
+	if buf.Len() >= len(body) {
+		return body, "", nil
+	}
+	return buf.Bytes(), string(compression), nil
+}
+
+// decompressBody decompresses a body by its content encoding
+func decompressBody(body []byte, contentEncoding string) ([]byte, error) {
+	var reader io.Reader
+	switch Compression(contentEncoding) {
+	case NoCompression:
+		return body, nil
+	case BrotliCompression:
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case ZstdCompression:
+		decoder, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderMaxMemory(maxDecompressedBodySize))
+		if err != nil {
+			return nil, err
+		}
+		defer decoder.Close()
+		reader = decoder
+	case GzipCompression:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %s", contentEncoding)
+	}
+
+	decompressed, err := io.ReadAll(io.LimitReader(reader, maxDecompressedBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s body: %v", contentEncoding, err)
+	}
+	if len(decompressed) > maxDecompressedBodySize {
+		return nil, fmt.Errorf("decompressed body exceeds %d bytes", maxDecompressedBodySize)
+	}
+	return decompressed, nil
+}
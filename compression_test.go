@@ -0,0 +1,64 @@
+package btcman
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedInscription(t *testing.T) {
+	body := bytes.Repeat([]byte("rollup batch "), 500)
+
+	for _, compression := range []Compression{BrotliCompression, ZstdCompression, GzipCompression} {
+		data, err := InscribeOptions{Compression: compression}.inscriptionData(body, "")
+		require.NoError(t, err)
+		assert.Equal(t, string(compression), data.ContentEncoding)
+		assert.Less(t, len(data.Body), len(body))
+
+		inscriptions := ParseInscriptions(newRevealTestTx(t, 0, data))
+		require.Len(t, inscriptions, 1)
+		assert.True(t, inscriptions[0].Decompressed)
+		assert.Equal(t, body, inscriptions[0].Body)
+		assert.Equal(t, data.Body, inscriptions[0].RawBody)
+	}
+}
+
+func TestCompressionKeepsRawBody(t *testing.T) {
+	body := []byte{0x01, 0x02, 0x03}
+
+	data, err := InscribeOptions{Compression: BrotliCompression}.inscriptionData(body, "")
+	require.NoError(t, err)
+	assert.Empty(t, data.ContentEncoding)
+	assert.Equal(t, body, data.Body)
+
+	inscriptions := ParseInscriptions(newRevealTestTx(t, 0, data))
+	require.Len(t, inscriptions, 1)
+	assert.False(t, inscriptions[0].Decompressed)
+	assert.Equal(t, body, inscriptions[0].Body)
+
+	_, err = InscribeOptions{Compression: BrotliCompression, ContentEncoding: "br"}.inscriptionData(body, "")
+	assert.Error(t, err)
+	_, err = InscribeOptions{Compression: "lzma"}.inscriptionData(body, "")
+	assert.Error(t, err)
+}
+
+func TestCorruptedCompressedInscription(t *testing.T) {
+	data, err := InscribeOptions{Compression: BrotliCompression}.inscriptionData(bytes.Repeat([]byte("rollup batch "), 500), "")
+	require.NoError(t, err)
+	data.Body = data.Body[:len(data.Body)/2]
+
+	inscriptions := ParseInscriptions(newRevealTestTx(t, 0, data))
+	require.Len(t, inscriptions, 1)
+	assert.Error(t, inscriptions[0].DecodeErr)
+	assert.False(t, inscriptions[0].Decompressed)
+	assert.Nil(t, inscriptions[0].Body)
+	assert.Equal(t, data.Body, inscriptions[0].RawBody)
+
+	data.ContentEncoding = "lzma"
+	inscriptions = ParseInscriptions(newRevealTestTx(t, 0, data))
+	require.Len(t, inscriptions, 1)
+	assert.Error(t, inscriptions[0].DecodeErr)
+	assert.Nil(t, inscriptions[0].Body)
+}
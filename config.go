@@ -13,6 +13,26 @@ type Config struct {
 	// PublicKey is the public key for the btc node wallet, required only for reader mode
 	PublicKey string `mapstructure:"PublicKey"`
 
+	// AddressType is the type of the wallet address: p2pkh, p2sh-p2wpkh, p2wpkh or p2tr, defaults to p2wpkh.
+	// UTXOs of all the other address types of the same key are spendable as well.
+	// A multisig wallet supports p2wsh or p2tr (script path), defaults to p2wsh
+	AddressType string `mapstructure:"AddressType"`
+
+	// MultisigPublicKeys are the compressed public keys of the multisig participants, setting them enables the multisig wallet
+	MultisigPublicKeys []string `mapstructure:"MultisigPublicKeys"`
+
+	// MultisigThreshold is the number of signatures required to spend from the multisig wallet
+	MultisigThreshold int `mapstructure:"MultisigThreshold"`
+
+	// MusigPublicKeys are the compressed public keys aggregated with MuSig2 into the taproot wallet key,
+	// setting them enables the MuSig2 wallet
+	MusigPublicKeys []string `mapstructure:"MusigPublicKeys"`
+
+	// MusigApproveProposal is called with every session proposed by another MuSig2 participant before signing it,
+	// an error rejects the proposal and the session can't complete. Without it every proposal spending only
+	// outputs of the wallet is signed, whatever its outputs
+	MusigApproveProposal func(*MusigSessionProposal) error `mapstructure:"-"`
+
 	// IndexerHost is the host of the indexer server
 	IndexerHost string `mapstructure:"IndexerHost"`
 
@@ -31,6 +51,46 @@ type Config struct {
 	// MinUtxoConsolidationAmount is the minimum number of UTXOS under the UtxoThreshold in order to perform a consolidation
 	MinUtxoConsolidationAmount int `mapstructure:"MinUtxoConsolidationAmount"`
 
+	// CoinSelection is the strategy selecting the utxos funding the inscriptions: branch-and-bound,
+	// largest-first, smallest-sufficient or privacy-aware, defaults to branch-and-bound
+	CoinSelection string `mapstructure:"CoinSelection"`
+
+	// AllowUnconfirmedChange allows spending the unconfirmed change outputs of the transactions sent by btcman
+	AllowUnconfirmedChange bool `mapstructure:"AllowUnconfirmedChange"`
+
+	// InscriptionHoldingAddress is the address receiving the inscriptions, defaults to the wallet address
+	InscriptionHoldingAddress string `mapstructure:"InscriptionHoldingAddress"`
+
+	// RevealOutValue is the value of the outputs receiving the inscriptions, in satoshi.
+	// Defaults to the minimal value that isn't dust for the receiving address
+	RevealOutValue int `mapstructure:"RevealOutValue"`
+
+	// OpReturnMaxSize is the maximum data size of an OP_RETURN output, defaults to the standard 80 bytes.
+	// Nodes with a relaxed datacarriersize policy relay larger outputs
+	OpReturnMaxSize int `mapstructure:"OpReturnMaxSize"`
+
+	// OpReturnMaxOutputs is the maximum number of OP_RETURN outputs of a transaction, defaults to the standard 1
+	OpReturnMaxOutputs int `mapstructure:"OpReturnMaxOutputs"`
+
+	// SpendableConfirmations is the number of confirmations after which a wallet utxo is spent, defaults to 1 if not set.
+	// With 0 the unconfirmed utxos are spent, otherwise the unconfirmed change of the transactions sent by btcman
+	// is spent if AllowUnconfirmedChange is set
+	SpendableConfirmations *int `mapstructure:"SpendableConfirmations"`
+
+	// CoinbaseConfirmations is the number of confirmations after which a coinbase utxo is spent,
+	// defaults to the coinbase maturity of the network
+	CoinbaseConfirmations int `mapstructure:"CoinbaseConfirmations"`
+
+	// FinalConfirmations is the number of confirmations after which an inscription is final, defaults to 6
+	FinalConfirmations int `mapstructure:"FinalConfirmations"`
+
+	// HeaderCheckpointHeight is the height of the trusted block the block headers are verified from.
+	// The headers read by the client are verified by the SPV rules only when a checkpoint hash is set
+	HeaderCheckpointHeight int `mapstructure:"HeaderCheckpointHeight"`
+
+	// HeaderCheckpointHash is the hash of the trusted block at the header checkpoint height
+	HeaderCheckpointHash string `mapstructure:"HeaderCheckpointHash"`
+
 	// EnableDebug is a flag for enabling debuging messages
 	EnableDebug bool `mapstructure:"EnableDebug"`
 }
@@ -38,7 +98,7 @@ type Config struct {
 func IsValidBtcConfig(cfg *Config) bool {
 	return cfg.Mode != "" &&
 		cfg.Net != "" &&
-		(cfg.PrivateKey != "" || cfg.PublicKey != "") &&
+		(cfg.PrivateKey != "" || cfg.PublicKey != "" || len(cfg.MultisigPublicKeys) > 0 || len(cfg.MusigPublicKeys) > 0) &&
 		cfg.IndexerHost != "" &&
 		cfg.IndexerPort != ""
 }
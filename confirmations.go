@@ -0,0 +1,111 @@
+package btcman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+)
+
+var (
+	// ErrTxDropped is returned when a transaction waited for is no longer known and its inputs are unspent
+	ErrTxDropped = errors.New("transaction was dropped")
+	// ErrTxDoubleSpent is returned when an input of a transaction waited for is spent by another transaction
+	ErrTxDoubleSpent = errors.New("transaction was double spent")
+)
+
+// confirmationPollInterval is the interval between the confirmation checks of WaitForConfirmations
+var confirmationPollInterval = 10 * time.Second
+
+// ConfirmationPolicy is the number of confirmations after which the outputs and inscriptions are considered settled
+type ConfirmationPolicy struct {
+	// Spendable is the depth of the wallet utxos spent by new transactions
+	Spendable int64
+	// Coinbase is the depth of the coinbase utxos spent by new transactions
+	Coinbase int64
+	// Final is the depth of the inscriptions considered final
+	Final int64
+}
+
+// confirmationsAt returns the confirmations of a transaction of the block at height, 0 if unconfirmed
+func confirmationsAt(height, blockchainHeight int32) int64 {
+	if height <= 0 || height > blockchainHeight {
+		return 0
+	}
+	// blockchain height - transaction block height + 1 in order to count the block of the transaction
+	return int64(blockchainHeight-height) + 1
+}
+
+// WaitForConfirmations returns once the transaction reaches the given confirmations, the final depth of the
+// confirmation policy if 0. A transaction not known by the indexer yet is waited for until the context is done.
+// Returns ErrTxDoubleSpent if an input of the transaction is spent by another one and ErrTxDropped if the
+// transaction is no longer known while its inputs are unspent
+func (client *Client) WaitForConfirmations(ctx context.Context, txid string, confirmations int64) error {
+	if confirmations <= 0 {
+		confirmations = client.confirmations.Final
+	}
+
+	ticker := time.NewTicker(confirmationPollInterval)
+	defer ticker.Stop()
+	var tx *wire.MsgTx
+	for {
+		result, err := client.GetTransaction(txid, true)
+		if err == nil {
+			if int64(result.Confirmations) >= confirmations {
+				return nil
+			}
+			if tx == nil {
+				tx, err = deserializeTransaction(result.Hex)
+				if err != nil {
+					return err
+				}
+			}
+		} else if tx != nil {
+			// the transaction was known, its inputs tell whether it was replaced or evicted
+			if err := client.CheckConflicts(tx); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckConflicts returns ErrTxDoubleSpent if an input of the transaction is spent by another transaction,
+// ErrTxDropped if no input is spent. An input spent by the transaction itself means the indexer still knows it.
+// Indexer errors are not returned, the conflicts are meant to be checked again later
+func (client *Client) CheckConflicts(tx *wire.MsgTx) error {
+	txHash := tx.TxHash()
+	spent := false
+	for _, txIn := range tx.TxIn {
+		prevTx, err := client.getMsgTx(txIn.PreviousOutPoint.Hash.String())
+		if err != nil {
+			return nil
+		}
+		if int(txIn.PreviousOutPoint.Index) >= len(prevTx.TxOut) {
+			return fmt.Errorf("input %s doesn't exist", txIn.PreviousOutPoint)
+		}
+		pkScript := prevTx.TxOut[txIn.PreviousOutPoint.Index].PkScript
+		spendingTx, _, err := client.findSpendingTx(txIn.PreviousOutPoint, pkScript)
+		if errors.Is(err, errOutputNotSpent) {
+			continue
+		}
+		if err != nil {
+			return nil
+		}
+		if spendingTx.TxHash() != txHash {
+			return fmt.Errorf("%w: input %s spent by %s", ErrTxDoubleSpent, txIn.PreviousOutPoint, spendingTx.TxHash())
+		}
+		spent = true
+	}
+	if !spent {
+		return fmt.Errorf("%w: %s", ErrTxDropped, txHash)
+	}
+	return nil
+}
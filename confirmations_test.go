@@ -0,0 +1,159 @@
+package btcman
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newConflictTestTxs returns a funding transaction and two transactions spending its output
+func newConflictTestTxs() (funding, tx, conflict *wire.MsgTx) {
+	pkScript := []byte{0x00, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14}
+	funding = wire.NewMsgTx(2)
+	funding.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: chainhash.HashH([]byte("coinbase"))}, nil, nil))
+	funding.AddTxOut(wire.NewTxOut(100_000, pkScript))
+
+	fundingOutPoint := wire.OutPoint{Hash: funding.TxHash(), Index: 0}
+	tx = wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&fundingOutPoint, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(90_000, pkScript))
+	conflict = wire.NewMsgTx(2)
+	conflict.AddTxIn(wire.NewTxIn(&fundingOutPoint, nil, nil))
+	conflict.AddTxOut(wire.NewTxOut(80_000, pkScript))
+	return funding, tx, conflict
+}
+
+func TestWaitForConfirmations(t *testing.T) {
+	defer func(interval time.Duration) { confirmationPollInterval = interval }(confirmationPollInterval)
+	confirmationPollInterval = time.Millisecond
+
+	_, tx, _ := newConflictTestTxs()
+	txHex, err := indexer.GetTxHex(tx)
+	require.NoError(t, err)
+	mockIndexer := new(mocks.Indexer)
+	mockIndexer.On("GetTransaction", mock.Anything, tx.TxHash().String(), true).Return(&btcjson.TxRawResult{Hex: txHex}, nil).Once()
+	mockIndexer.On("GetTransaction", mock.Anything, tx.TxHash().String(), true).Return(&btcjson.TxRawResult{Hex: txHex, Confirmations: 2}, nil).Once()
+	mockIndexer.On("GetTransaction", mock.Anything, tx.TxHash().String(), true).Return(&btcjson.TxRawResult{Hex: txHex, Confirmations: 6}, nil)
+	client := &Client{IndexerClient: mockIndexer, confirmations: ConfirmationPolicy{Final: 6}}
+
+	require.NoError(t, client.WaitForConfirmations(context.Background(), tx.TxHash().String(), 2))
+	// the final depth of the policy by default
+	require.NoError(t, client.WaitForConfirmations(context.Background(), tx.TxHash().String(), 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = client.WaitForConfirmations(ctx, tx.TxHash().String(), 7)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestWaitForConfirmationsConflicts(t *testing.T) {
+	defer func(interval time.Duration) { confirmationPollInterval = interval }(confirmationPollInterval)
+	confirmationPollInterval = time.Millisecond
+
+	testCases := []struct {
+		name        string
+		spentBy     func(tx, conflict *wire.MsgTx) []*wire.MsgTx
+		expectedErr error
+	}{
+		{
+			name:        "double spent",
+			spentBy:     func(tx, conflict *wire.MsgTx) []*wire.MsgTx { return []*wire.MsgTx{conflict} },
+			expectedErr: ErrTxDoubleSpent,
+		},
+		{
+			name:        "dropped",
+			spentBy:     func(tx, conflict *wire.MsgTx) []*wire.MsgTx { return nil },
+			expectedErr: ErrTxDropped,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			funding, tx, conflict := newConflictTestTxs()
+			txHex, err := indexer.GetTxHex(tx)
+			require.NoError(t, err)
+			mockIndexer := new(mocks.Indexer)
+			mockIndexer.On("GetTransaction", mock.Anything, tx.TxHash().String(), true).Return(&btcjson.TxRawResult{Hex: txHex}, nil).Once()
+			mockIndexer.On("GetTransaction", mock.Anything, tx.TxHash().String(), true).Return((*btcjson.TxRawResult)(nil), errors.New("not found"))
+			mockTransaction(t, mockIndexer, funding)
+
+			history := []*indexer.Transaction{{TxHash: funding.TxHash().String(), Height: 100}}
+			for _, spendingTx := range tc.spentBy(tx, conflict) {
+				mockTransaction(t, mockIndexer, spendingTx)
+				history = append(history, &indexer.Transaction{TxHash: spendingTx.TxHash().String(), Height: 101})
+			}
+			mockIndexer.On("GetHistory", mock.Anything, funding.TxOut[0].PkScript).Return(history, nil)
+			client := &Client{IndexerClient: mockIndexer}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = client.WaitForConfirmations(ctx, tx.TxHash().String(), 1)
+			assert.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestListUnspentConfirmations(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	client := newTestWriterClient(t, mockIndexer)
+	pkScript := client.keychain.GetPkScript()
+
+	regularTx, _, _ := newConflictTestTxs()
+	coinbaseTx := wire.NewMsgTx(2)
+	coinbaseTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), []byte{0x01, 0x02}, nil))
+	coinbaseTx.AddTxOut(wire.NewTxOut(5_000_000_000, pkScript))
+
+	mockIndexer.ExpectedCalls = nil
+	mockTransaction(t, mockIndexer, regularTx)
+	mockTransaction(t, mockIndexer, coinbaseTx)
+	mockIndexer.On("ListUnspent", mock.Anything, pkScript).Return([]*indexer.UTXO{
+		{TxHash: chainhash.HashH([]byte("deep")).String(), Height: 900},
+		{TxHash: regularTx.TxHash().String(), Height: 995},
+		{TxHash: coinbaseTx.TxHash().String(), Height: 995},
+		{TxHash: chainhash.HashH([]byte("mempool")).String(), Height: 0},
+	}, nil)
+	mockIndexer.On("ListUnspent", mock.Anything, mock.Anything).Return([]*indexer.UTXO{}, nil)
+	mockIndexer.On("GetBlockchainInfo", mock.Anything).Return(&indexer.BlockChainInfo{Height: 1000}, nil)
+
+	// the immature coinbase output and the unconfirmed output are not spendable
+	client.confirmations = loadConfirmationPolicy(&Config{}, &chaincfg.RegressionNetParams)
+	utxos, err := client.ListUnspent()
+	require.NoError(t, err)
+	require.Len(t, utxos, 2)
+	assert.Equal(t, 900, utxos[0].Height)
+	assert.Equal(t, regularTx.TxHash().String(), utxos[1].TxHash)
+
+	// the coinbase flags of the funding transactions are cached
+	_, err = client.ListUnspent()
+	require.NoError(t, err)
+	mockIndexer.AssertNumberOfCalls(t, "GetTransaction", 2)
+
+	client.confirmations = ConfirmationPolicy{Spendable: 6, Coinbase: 6}
+	utxos, err = client.ListUnspent()
+	require.NoError(t, err)
+	assert.Len(t, utxos, 3)
+}
+
+func TestLoadConfirmationPolicy(t *testing.T) {
+	policy := loadConfirmationPolicy(&Config{}, &chaincfg.MainNetParams)
+	assert.Equal(t, ConfirmationPolicy{Spendable: 1, Coinbase: 100, Final: 6}, policy)
+
+	spendable := 2
+	policy = loadConfirmationPolicy(&Config{SpendableConfirmations: &spendable, CoinbaseConfirmations: 101, FinalConfirmations: 3}, &chaincfg.MainNetParams)
+	assert.Equal(t, ConfirmationPolicy{Spendable: 2, Coinbase: 101, Final: 3}, policy)
+
+	spendable = 0
+	policy = loadConfirmationPolicy(&Config{SpendableConfirmations: &spendable}, &chaincfg.MainNetParams)
+	assert.Equal(t, ConfirmationPolicy{Spendable: 0, Coinbase: 100, Final: 6}, policy)
+}
@@ -5,4 +5,6 @@ const (
 	DEFAULT_CONSOLIDATION_TRANSACTION_FEE = 1000
 	DEFAULT_UTXO_THRESHOLD                = 5000
 	DEFAULT_MIN_UTXO_CONSOLIDATION_AMOUNT = 10
+	DEFAULT_SPENDABLE_CONFIRMATIONS       = 1
+	DEFAULT_FINAL_CONFIRMATIONS           = 6
 )
@@ -0,0 +1,316 @@
+package btcman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// ordinals envelope tags
+const (
+	TagContentType     byte = 1
+	TagPointer         byte = 2
+	TagParent          byte = 3
+	TagMetadata        byte = 5
+	TagMetaprotocol    byte = 7
+	TagContentEncoding byte = 9
+	TagDelegate        byte = 11
+)
+
+// maxPushSize is the maximum size of a single tapscript data push
+const maxPushSize = 520
+
+// envelopeProtocolID is the protocol identifier pushed right after OP_FALSE OP_IF
+var envelopeProtocolID = []byte("ord")
+
+// ErrNoInscription is returned when a transaction doesn't carry an inscription envelope
+var ErrNoInscription = errors.New("transaction has no inscription")
+
+// Inscription is an ordinals inscription decoded from a reveal transaction witness
+type Inscription struct {
+	// Index is the position of the inscription among all the envelopes of the transaction
+	Index int
+	// InputIndex is the index of the transaction input whose witness carries the envelope
+	InputIndex int
+	// ContentType is the value of the content type tag
+	ContentType string
+	// ContentEncoding is the value of the content encoding tag, e.g. br
+	ContentEncoding string
+	// Metadata is the CBOR encoded metadata, concatenated from all the metadata tags
+	Metadata []byte
+	// Metaprotocol is the value of the metaprotocol tag
+	Metaprotocol string
+	// Pointer is the offset of the sat the inscription is made on, nil if not set or invalid
+	Pointer *uint64
+	// Parent is the parent inscription, nil if not set or invalid
+	Parent *InscriptionID
+	// Delegate is the inscription whose content is shown instead of the body, nil if not set or invalid
+	Delegate *InscriptionID
+	// Body is the content of the inscription, decompressed if the content encoding is br, zstd or gzip.
+	// It's nil if the body can't be decoded
+	Body []byte
+	// RawBody is the concatenation of all the data pushes after the body tag
+	RawBody []byte
+	// Decompressed is true if Body was decompressed from RawBody
+	Decompressed bool
+	// DecodeErr is the error decoding RawBody by the content encoding, e.g. a corrupted or unsupported encoding
+	DecodeErr error
+	// Tags are the values of every tag of the envelope by tag. The values of a tag keep the order they appear in,
+	// the order between different tags is not kept
+	Tags map[byte][][]byte
+}
+
+// ParseInscriptions returns the inscriptions of all the envelopes in the inputs of a transaction
+func ParseInscriptions(tx *wire.MsgTx) []*Inscription {
+	inscriptions := []*Inscription{}
+	for inputIndex, txIn := range tx.TxIn {
+		script := tapscriptFromWitness(txIn.Witness)
+		if script == nil {
+			continue
+		}
+		for _, payload := range parseEnvelopes(script) {
+			inscription := newInscription(payload)
+			inscription.Index = len(inscriptions)
+			inscription.InputIndex = inputIndex
+			inscriptions = append(inscriptions, inscription)
+		}
+	}
+	return inscriptions
+}
+
+// tapscriptFromWitness returns the leaf script of a taproot script path spend, nil for any other witness
+func tapscriptFromWitness(witness wire.TxWitness) []byte {
+	if len(witness) < 2 {
+		return nil
+	}
+	// the annex, if present, is the last element and starts with 0x50
+	if last := witness[len(witness)-1]; len(last) > 0 && last[0] == txscript.TaprootAnnexTag {
+		witness = witness[:len(witness)-1]
+		if len(witness) < 2 {
+			return nil
+		}
+	}
+	controlBlock := witness[len(witness)-1]
+	if len(controlBlock) < txscript.ControlBlockBaseSize ||
+		txscript.TapscriptLeafVersion(controlBlock[0]&txscript.TaprootLeafMask) != txscript.BaseLeafVersion {
+		return nil
+	}
+	return witness[len(witness)-2]
+}
+
+// parseEnvelopes walks the opcodes of a tapscript and returns the pushes following the protocol identifier
+// of every OP_FALSE OP_IF "ord" ... OP_ENDIF envelope. Envelopes containing non push opcodes are skipped.
+func parseEnvelopes(script []byte) [][][]byte {
+	envelopes := [][][]byte{}
+
+	// the script is tokenized up front, parsing stops at the first malformed opcode
+	type token struct {
+		opcode byte
+		data   []byte
+	}
+	tokens := []token{}
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		tokens = append(tokens, token{opcode: tokenizer.Opcode(), data: tokenizer.Data()})
+	}
+
+	for i := 0; i+2 < len(tokens); i++ {
+		if tokens[i].opcode != txscript.OP_FALSE || tokens[i+1].opcode != txscript.OP_IF ||
+			!isPush(tokens[i+2].opcode) || !bytes.Equal(tokens[i+2].data, envelopeProtocolID) {
+			continue
+		}
+
+		payload := [][]byte{}
+		valid := false
+		j := i + 3
+		for ; j < len(tokens); j++ {
+			if tokens[j].opcode == txscript.OP_ENDIF {
+				valid = true
+				break
+			}
+			data, ok := pushData(tokens[j].opcode, tokens[j].data)
+			if !ok {
+				break
+			}
+			payload = append(payload, data)
+		}
+		if valid {
+			envelopes = append(envelopes, payload)
+		}
+		i = j
+	}
+	return envelopes
+}
+
+// isPush returns true for opcodes pushing data on the stack
+func isPush(opcode byte) bool {
+	return opcode <= txscript.OP_PUSHDATA4 || opcode == txscript.OP_1NEGATE ||
+		(opcode >= txscript.OP_1 && opcode <= txscript.OP_16)
+}
+
+// pushData returns the data pushed by an opcode, small integer opcodes push their value as a single byte
+func pushData(opcode byte, data []byte) ([]byte, bool) {
+	switch {
+	case opcode <= txscript.OP_PUSHDATA4:
+		if data == nil {
+			return []byte{}, true
+		}
+		return data, true
+	case opcode == txscript.OP_1NEGATE:
+		return []byte{0x81}, true
+	case opcode >= txscript.OP_1 && opcode <= txscript.OP_16:
+		return []byte{opcode - txscript.OP_1 + 1}, true
+	default:
+		return nil, false
+	}
+}
+
+// newInscription builds an inscription from the envelope pushes, tags and values alternate until
+// the empty body tag, every push after it is part of the body
+func newInscription(payload [][]byte) *Inscription {
+	inscription := &Inscription{
+		Tags: make(map[byte][][]byte),
+	}
+
+	i := 0
+	for ; i+1 < len(payload); i += 2 {
+		tag := payload[i]
+		if len(tag) == 0 {
+			break
+		}
+		if len(tag) != 1 {
+			continue
+		}
+		inscription.Tags[tag[0]] = append(inscription.Tags[tag[0]], payload[i+1])
+	}
+	if i < len(payload) && len(payload[i]) == 0 {
+		inscription.RawBody = []byte{}
+		for _, chunk := range payload[i+1:] {
+			inscription.RawBody = append(inscription.RawBody, chunk...)
+		}
+	}
+	inscription.Body = inscription.RawBody
+
+	if values := inscription.Tags[TagContentType]; len(values) > 0 {
+		inscription.ContentType = string(values[0])
+	}
+	if values := inscription.Tags[TagContentEncoding]; len(values) > 0 {
+		inscription.ContentEncoding = string(values[0])
+		if inscription.ContentEncoding != "" {
+			inscription.Body, inscription.DecodeErr = decompressBody(inscription.RawBody, inscription.ContentEncoding)
+			inscription.Decompressed = inscription.DecodeErr == nil
+		}
+	}
+	if values := inscription.Tags[TagMetadata]; len(values) > 0 {
+		inscription.Metadata = bytes.Join(values, nil)
+	}
+	if values := inscription.Tags[TagMetaprotocol]; len(values) > 0 {
+		inscription.Metaprotocol = string(values[0])
+	}
+	if values := inscription.Tags[TagPointer]; len(values) > 0 {
+		inscription.Pointer = decodePointer(values[0])
+	}
+	if values := inscription.Tags[TagParent]; len(values) > 0 {
+		inscription.Parent = decodeInscriptionID(values[0])
+	}
+	if values := inscription.Tags[TagDelegate]; len(values) > 0 {
+		inscription.Delegate = decodeInscriptionID(values[0])
+	}
+	return inscription
+}
+
+// DecodeMetadata unmarshals the CBOR metadata of the inscription into v
+func (inscription *Inscription) DecodeMetadata(v interface{}) error {
+	if len(inscription.Metadata) == 0 {
+		return errors.New("inscription has no metadata")
+	}
+	return cbor.Unmarshal(inscription.Metadata, v)
+}
+
+// InscriptionID identifies an inscription by its reveal transaction and index
+type InscriptionID struct {
+	TxHash chainhash.Hash
+	Index  uint32
+}
+
+// NewInscriptionIDFromStr parses an inscription id in the <txid>i<index> format
+func NewInscriptionIDFromStr(id string) (*InscriptionID, error) {
+	separator := strings.LastIndex(id, "i")
+	if separator == -1 {
+		return nil, fmt.Errorf("invalid inscription id %s", id)
+	}
+	txHash, err := chainhash.NewHashFromStr(id[:separator])
+	if err != nil {
+		return nil, fmt.Errorf("invalid inscription id %s: %v", id, err)
+	}
+	index, err := strconv.ParseUint(id[separator+1:], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid inscription id %s: %v", id, err)
+	}
+	return &InscriptionID{TxHash: *txHash, Index: uint32(index)}, nil
+}
+
+// String returns the inscription id in the <txid>i<index> format
+func (id InscriptionID) String() string {
+	return fmt.Sprintf("%si%d", id.TxHash, id.Index)
+}
+
+// encode serializes the id as the txid bytes followed by the little endian index without trailing zeros
+func (id InscriptionID) encode() []byte {
+	index := make([]byte, 4)
+	binary.LittleEndian.PutUint32(index, id.Index)
+	return append(id.TxHash.CloneBytes(), trimTrailingZeros(index)...)
+}
+
+// decodeInscriptionID is the inverse of InscriptionID.encode, nil if the value is invalid
+func decodeInscriptionID(value []byte) *InscriptionID {
+	if len(value) < chainhash.HashSize || len(value) > chainhash.HashSize+4 {
+		return nil
+	}
+	id := &InscriptionID{}
+	copy(id.TxHash[:], value[:chainhash.HashSize])
+	index := make([]byte, 4)
+	copy(index, value[chainhash.HashSize:])
+	id.Index = binary.LittleEndian.Uint32(index)
+	return id
+}
+
+// encodePointer serializes the pointer as little endian without trailing zeros
+func encodePointer(pointer uint64) []byte {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, pointer)
+	return trimTrailingZeros(value)
+}
+
+// decodePointer is the inverse of encodePointer, nil if the value doesn't fit in 64 bits
+func decodePointer(value []byte) *uint64 {
+	if len(value) > 8 {
+		for _, b := range value[8:] {
+			if b != 0 {
+				return nil
+			}
+		}
+		value = value[:8]
+	}
+	padded := make([]byte, 8)
+	copy(padded, value)
+	pointer := binary.LittleEndian.Uint64(padded)
+	return &pointer
+}
+
+// trimTrailingZeros removes the trailing zero bytes of a little endian integer
+func trimTrailingZeros(value []byte) []byte {
+	end := len(value)
+	for end > 0 && value[end-1] == 0 {
+		end--
+	}
+	return value[:end]
+}
@@ -0,0 +1,154 @@
+package btcman
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newRevealTestTx(t *testing.T, witnessInput int, data ...InscriptionData) *wire.MsgTx {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for i := 0; i <= witnessInput; i++ {
+		// key path like witness on the other inputs
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(i)}, nil, wire.TxWitness{make([]byte, 64)}))
+	}
+	for i, d := range data {
+		txCtxData, err := createInscriptionTxCtxData(&chaincfg.RegressionNetParams, d)
+		require.NoError(t, err)
+		witness := wire.TxWitness{make([]byte, 64), txCtxData.inscriptionScript, txCtxData.controlBlockWitness}
+		if i == 0 {
+			tx.TxIn[witnessInput].Witness = witness
+		} else {
+			tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(witnessInput + i)}, nil, witness))
+		}
+	}
+	return tx
+}
+
+func TestParseInscriptions(t *testing.T) {
+	largeBody := bytes.Repeat([]byte{0xab, 0xcd, 0xef}, 1000)
+	tx := newRevealTestTx(t, 1,
+		InscriptionData{ContentType: "application/octet-stream", Body: largeBody},
+		InscriptionData{ContentType: "text/plain;charset=utf-8", Body: []byte("hello")},
+	)
+
+	inscriptions := ParseInscriptions(tx)
+	require.Len(t, inscriptions, 2)
+
+	assert.Equal(t, 0, inscriptions[0].Index)
+	assert.Equal(t, 1, inscriptions[0].InputIndex)
+	assert.Equal(t, "application/octet-stream", inscriptions[0].ContentType)
+	assert.Equal(t, largeBody, inscriptions[0].Body)
+
+	assert.Equal(t, 1, inscriptions[1].Index)
+	assert.Equal(t, 2, inscriptions[1].InputIndex)
+	assert.Equal(t, "text/plain;charset=utf-8", inscriptions[1].ContentType)
+	assert.Equal(t, []byte("hello"), inscriptions[1].Body)
+}
+
+func TestParseEnvelopes(t *testing.T) {
+	script, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte("ord")).
+		AddOp(txscript.OP_1).AddData([]byte("text/plain")).
+		AddData([]byte{0x0b}).AddData([]byte("odd")).
+		AddOp(txscript.OP_0).AddData([]byte("first")).AddData([]byte(" body")).
+		AddOp(txscript.OP_ENDIF).
+		// envelope with a non push opcode is ignored
+		AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte("ord")).
+		AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_ENDIF).
+		AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte("ord")).
+		AddOp(txscript.OP_0).AddData([]byte("second")).
+		AddOp(txscript.OP_ENDIF).
+		Script()
+	require.NoError(t, err)
+
+	envelopes := parseEnvelopes(script)
+	require.Len(t, envelopes, 2)
+
+	first := newInscription(envelopes[0])
+	assert.Equal(t, "text/plain", first.ContentType)
+	assert.Equal(t, []byte("first body"), first.Body)
+	assert.Equal(t, [][]byte{[]byte("odd")}, first.Tags[0x0b])
+
+	second := newInscription(envelopes[1])
+	assert.Equal(t, "", second.ContentType)
+	assert.Equal(t, []byte("second"), second.Body)
+}
+
+func TestDecodeInscription(t *testing.T) {
+	tx := newRevealTestTx(t, 0, InscriptionData{ContentType: "application/octet-stream", Body: []byte("proof")})
+	txHex, err := indexer.GetTxHex(tx)
+	require.NoError(t, err)
+
+	mockIndexer := new(mocks.Indexer)
+	mockIndexer.On("GetTransaction", mock.Anything, "reveal", false).Return(&btcjson.TxRawResult{Hex: txHex}, nil)
+	mockIndexer.On("GetTransaction", mock.Anything, "plain", false).Return(&btcjson.TxRawResult{Hex: plainTxHex(t)}, nil)
+	btcman := &Client{IndexerClient: mockIndexer}
+
+	inscription, err := btcman.DecodeInscription("reveal")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("proof"), inscription.Body)
+
+	_, err = btcman.DecodeInscription("plain")
+	assert.ErrorIs(t, err, ErrNoInscription)
+}
+
+func plainTxHex(t *testing.T) string {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, wire.TxWitness{make([]byte, 72), make([]byte, 33)}))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+	txHex, err := indexer.GetTxHex(tx)
+	require.NoError(t, err)
+	return txHex
+}
+
+func TestParseInscriptionTags(t *testing.T) {
+	parent, err := NewInscriptionIDFromStr("6fb976ab49dcec017f1e201e84395983204ae1a7c2abf7ced0a85d692e442799i300")
+	require.NoError(t, err)
+	delegate, err := NewInscriptionIDFromStr("6fb976ab49dcec017f1e201e84395983204ae1a7c2abf7ced0a85d692e442799i0")
+	require.NoError(t, err)
+	pointer := uint64(1)
+
+	opts := InscribeOptions{
+		ContentEncoding: "br",
+		Metaprotocol:    "grail-rollup",
+		Metadata:        map[string]interface{}{"batch": 42, "padding": bytes.Repeat([]byte{1}, 600)},
+		Pointer:         &pointer,
+		Parent:          parent,
+		Delegate:        delegate,
+	}
+	data, err := opts.inscriptionData([]byte("batch"), "")
+	require.NoError(t, err)
+
+	inscriptions := ParseInscriptions(newRevealTestTx(t, 0, data))
+	require.Len(t, inscriptions, 1)
+	inscription := inscriptions[0]
+
+	assert.Equal(t, defaultContentType, inscription.ContentType)
+	assert.Equal(t, "br", inscription.ContentEncoding)
+	assert.Equal(t, "grail-rollup", inscription.Metaprotocol)
+	assert.Equal(t, &pointer, inscription.Pointer)
+	assert.Equal(t, parent, inscription.Parent)
+	assert.Equal(t, delegate, inscription.Delegate)
+	assert.Equal(t, parent.String(), inscription.Parent.String())
+	assert.Len(t, inscription.Tags[TagMetadata], 2)
+	// the body isn't brotli encoded
+	assert.Equal(t, []byte("batch"), inscription.RawBody)
+	assert.Error(t, inscription.DecodeErr)
+
+	metadata := struct {
+		Batch int `cbor:"batch"`
+	}{}
+	require.NoError(t, inscription.DecodeMetadata(&metadata))
+	assert.Equal(t, 42, metadata.Batch)
+}
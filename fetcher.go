@@ -3,7 +3,11 @@ package btcman
 import (
 	"context"
 	"encoding/hex"
+	"fmt"
+	"sync"
 
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/grail-rollup/btcman/indexer"
@@ -11,30 +15,83 @@ import (
 )
 
 // PreviousOutPointFetcher implements txscript.PrevOutputFetcher interface
-// and is used during the signing to retrieve the previous transaction
+// and is used during the signing to retrieve the previous transaction.
+// The outputs are cached, so every previous transaction is fetched from the indexer once
 type PreviousOutPointFetcher struct {
 	indexer indexer.Indexerer
 	logger  log.Logger
+	lock    sync.Mutex
+	cache   map[wire.OutPoint]*wire.TxOut
 }
 
 func NewPreviousOutPointFetcher(indexer indexer.Indexerer, logger log.Logger) txscript.PrevOutputFetcher {
 	return &PreviousOutPointFetcher{
 		indexer: indexer,
 		logger:  logger,
+		cache:   make(map[wire.OutPoint]*wire.TxOut),
 	}
 }
 
-// FetchPrevOutput retursn a transaction out by a given outPoint
+// NewUtxoPrevOutFetcher returns a fetcher of the previous outputs of an already fetched utxo set,
+// outputs of utxos without a known script are fetched from the indexer
+func NewUtxoPrevOutFetcher(utxos []*indexer.UTXO, indexer indexer.Indexerer, logger log.Logger) txscript.PrevOutputFetcher {
+	fetcher := &PreviousOutPointFetcher{
+		indexer: indexer,
+		logger:  logger,
+		cache:   make(map[wire.OutPoint]*wire.TxOut, len(utxos)),
+	}
+	for _, utxo := range utxos {
+		if utxo.PkScript == nil {
+			continue
+		}
+		hash, err := chainhash.NewHashFromStr(utxo.TxHash)
+		if err != nil {
+			continue
+		}
+		fetcher.cache[*wire.NewOutPoint(hash, uint32(utxo.TxPos))] = wire.NewTxOut(utxo.Value, utxo.PkScript)
+	}
+	return fetcher
+}
+
+// FetchPrevOutput retursn a transaction out by a given outPoint, nil if it can't be retrieved
 func (f *PreviousOutPointFetcher) FetchPrevOutput(outPoint wire.OutPoint) *wire.TxOut {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if txOut, ok := f.cache[outPoint]; ok {
+		return txOut
+	}
+
 	tx, err := f.indexer.GetTransaction(context.Background(), outPoint.Hash.String(), true)
 	if err != nil {
 		f.logger.Error("Failed to get transaction", "err", err)
+		return nil
 	}
-	vout := tx.Vout[outPoint.Index]
-	scriptPub, err := hex.DecodeString(vout.ScriptPubKey.Hex)
-	if err != nil {
-		f.logger.Error("Faield to decode scriptPubKey", "err", err)
+	for index, vout := range tx.Vout {
+		scriptPub, err := hex.DecodeString(vout.ScriptPubKey.Hex)
+		if err != nil {
+			f.logger.Error("Faield to decode scriptPubKey", "err", err)
+			return nil
+		}
+		amount, err := btcutil.NewAmount(vout.Value)
+		if err != nil {
+			f.logger.Error("Failed to parse output value", "err", err)
+			return nil
+		}
+		f.cache[*wire.NewOutPoint(&outPoint.Hash, uint32(index))] = wire.NewTxOut(int64(amount), scriptPub)
+	}
+	return f.cache[outPoint]
+}
+
+// fetchPrevOuts returns the previous outputs of all the transaction inputs, or an error if one of them is missing
+func fetchPrevOuts(tx *wire.MsgTx, prevOutFetcher txscript.PrevOutputFetcher) ([]*wire.TxOut, error) {
+	prevOuts := make([]*wire.TxOut, len(tx.TxIn))
+	for idx, txIn := range tx.TxIn {
+		prevOut := prevOutFetcher.FetchPrevOutput(txIn.PreviousOutPoint)
+		if prevOut == nil {
+			return nil, fmt.Errorf("failed to fetch previous output %s of input %d", txIn.PreviousOutPoint, idx)
+		}
+		prevOuts[idx] = prevOut
 	}
-	txOut := wire.NewTxOut(int64(vout.Value), scriptPub)
-	return txOut
+	return prevOuts, nil
 }
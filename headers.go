@@ -0,0 +1,371 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+)
+
+// maxHeadersPerRequest is the number of headers fetched by one blockchain.block.headers request
+const maxHeadersPerRequest = 2016
+
+// medianTimeBlocks is the number of blocks of the median time past
+const medianTimeBlocks = 11
+
+var (
+	// ErrHeaderBeforeCheckpoint is returned for the headers below the trusted headers of the checkpoint
+	ErrHeaderBeforeCheckpoint = errors.New("header is before the header checkpoint")
+	// ErrCheckpointMismatch is returned when the indexer chain doesn't contain the header checkpoint
+	ErrCheckpointMismatch = errors.New("indexer chain doesn't match the header checkpoint")
+)
+
+// HeaderCheckpoint is the trusted block the header chain is validated from
+type HeaderCheckpoint struct {
+	Height int32
+	Hash   chainhash.Hash
+}
+
+// HeaderStore keeps the header chain of the indexer validated from a checkpoint: every header must link
+// to the previous one, satisfy its proof of work, the difficulty retarget rules of the network and be after
+// the median time of the previous blocks. The headers linking back to the checkpoint down to the last
+// retarget are trusted, so the retarget and median time rules apply from the first header after the checkpoint
+type HeaderStore struct {
+	indexerClient indexer.Indexerer
+	params        *chaincfg.Params
+	checkpoint    HeaderCheckpoint
+	timeSource    blockchain.MedianTimeSource
+	lock          sync.Mutex
+	// startHeight is the height of headers[0]
+	startHeight int32
+	headers     []wire.BlockHeader
+	hashes      []chainhash.Hash
+}
+
+// NewHeaderStore creates a header store validating the chain of the indexer from a checkpoint,
+// the headers are fetched on the first read
+func NewHeaderStore(indexerClient indexer.Indexerer, params *chaincfg.Params, checkpoint HeaderCheckpoint) *HeaderStore {
+	return &HeaderStore{
+		indexerClient: indexerClient,
+		params:        params,
+		checkpoint:    checkpoint,
+		timeSource:    blockchain.NewMedianTime(),
+	}
+}
+
+// Header returns the validated header at a height, the chain is synced up to it if needed
+func (s *HeaderStore) Header(height int32) (*wire.BlockHeader, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.syncTo(height); err != nil {
+		return nil, err
+	}
+	if height < s.startHeight {
+		return nil, fmt.Errorf("%w: height %d", ErrHeaderBeforeCheckpoint, height)
+	}
+	header := s.headers[height-s.startHeight]
+	return &header, nil
+}
+
+// VerifyTip syncs the chain up to the tip reported by the indexer and checks the tip header matches it.
+// A tip replacing the validated header at the same height is validated as a reorg
+func (s *HeaderStore) VerifyTip(height int32, headerHex string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.syncTo(height); err != nil {
+		return err
+	}
+	tip, err := deserializeBlockHeader(headerHex)
+	if err != nil {
+		return err
+	}
+	if height < s.startHeight {
+		return fmt.Errorf("tip header at height %d doesn't match the validated chain", height)
+	}
+	if tip.BlockHash() != s.hashes[height-s.startHeight] {
+		// the indexer switched to a competing block without extending the validated chain
+		if err := s.reorg(height); err != nil {
+			return err
+		}
+		if tip.BlockHash() != s.hashes[height-s.startHeight] {
+			return fmt.Errorf("tip header at height %d doesn't match the validated chain", height)
+		}
+	}
+	return nil
+}
+
+// TipHeight returns the height of the last validated header, -1 before the first sync
+func (s *HeaderStore) TipHeight() int32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.tipHeight()
+}
+
+func (s *HeaderStore) tipHeight() int32 {
+	return s.startHeight + int32(len(s.headers)) - 1
+}
+
+// syncTo validates the headers up to height, replacing the validated headers above a fork if the indexer reorged
+func (s *HeaderStore) syncTo(height int32) error {
+	if len(s.headers) == 0 {
+		if err := s.loadCheckpoint(); err != nil {
+			return err
+		}
+	}
+
+	for s.tipHeight() < height {
+		tipHeight := s.tipHeight()
+		headers, err := s.fetchHeaders(tipHeight+1, int(height-tipHeight))
+		if err != nil {
+			return err
+		}
+		if len(headers) == 0 {
+			return fmt.Errorf("indexer has no header at height %d", tipHeight+1)
+		}
+		if headers[0].PrevBlock != s.hashes[len(s.hashes)-1] {
+			if err := s.reorg(height); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, header := range headers {
+			if err := s.connect(header); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadCheckpoint fetches the checkpoint header and the headers before it down to the last retarget,
+// which are trusted since they link to the checkpoint hash
+func (s *HeaderStore) loadCheckpoint() error {
+	blocksPerRetarget := s.BlocksPerRetarget()
+	startHeight := s.checkpoint.Height - s.checkpoint.Height%blocksPerRetarget
+	if s.checkpoint.Height-startHeight < medianTimeBlocks-1 {
+		startHeight = s.checkpoint.Height - (medianTimeBlocks - 1)
+	}
+	if startHeight < 0 {
+		startHeight = 0
+	}
+
+	headers, err := s.fetchHeaders(startHeight, int(s.checkpoint.Height-startHeight+1))
+	if err != nil {
+		return err
+	}
+	if len(headers) != int(s.checkpoint.Height-startHeight+1) {
+		return fmt.Errorf("%w: missing headers before height %d", ErrCheckpointMismatch, s.checkpoint.Height)
+	}
+	hashes := make([]chainhash.Hash, len(headers))
+	for i := len(headers) - 1; i >= 0; i-- {
+		hashes[i] = headers[i].BlockHash()
+		if i == len(headers)-1 {
+			if hashes[i] != s.checkpoint.Hash {
+				return fmt.Errorf("%w: hash %s at height %d", ErrCheckpointMismatch, hashes[i], s.checkpoint.Height)
+			}
+		} else if headers[i+1].PrevBlock != hashes[i] {
+			return fmt.Errorf("%w: header at height %d doesn't link", ErrCheckpointMismatch, startHeight+int32(i))
+		}
+	}
+
+	s.startHeight = startHeight
+	s.headers = headers
+	s.hashes = hashes
+	return nil
+}
+
+// connect validates a header against the tip and appends it
+func (s *HeaderStore) connect(header wire.BlockHeader) error {
+	tip := s.node(s.tipHeight())
+	height := tip.Height() + 1
+	if header.PrevBlock != s.hashes[len(s.hashes)-1] {
+		return fmt.Errorf("header at height %d doesn't link to the previous header", height)
+	}
+	if err := blockchain.CheckBlockHeaderSanity(&header, s.params.PowLimit, s.timeSource, blockchain.BFNone); err != nil {
+		return fmt.Errorf("invalid header at height %d: %v", height, err)
+	}
+	if err := blockchain.CheckBlockHeaderContext(&header, tip, blockchain.BFNone, s, true); err != nil {
+		return fmt.Errorf("invalid header at height %d: %v", height, err)
+	}
+	s.headers = append(s.headers, header)
+	s.hashes = append(s.hashes, header.BlockHash())
+	return nil
+}
+
+// reorg replaces the validated headers above the fork point with the indexer chain up to height,
+// if the replacing headers are valid and carry at least as much work. The indexer is followed between
+// branches of equal work, e.g. competing blocks at the same height
+func (s *HeaderStore) reorg(height int32) error {
+	forkHeight := s.tipHeight()
+	for ; forkHeight >= s.checkpoint.Height; forkHeight-- {
+		headers, err := s.fetchHeaders(forkHeight, 1)
+		if err != nil {
+			return err
+		}
+		if len(headers) == 1 && headers[0].BlockHash() == s.hashes[forkHeight-s.startHeight] {
+			break
+		}
+	}
+	if forkHeight < s.checkpoint.Height {
+		return fmt.Errorf("%w: the indexer chain forks before the checkpoint", ErrCheckpointMismatch)
+	}
+
+	branch := &HeaderStore{
+		params:      s.params,
+		timeSource:  s.timeSource,
+		startHeight: s.startHeight,
+		headers:     append([]wire.BlockHeader{}, s.headers[:forkHeight-s.startHeight+1]...),
+		hashes:      append([]chainhash.Hash{}, s.hashes[:forkHeight-s.startHeight+1]...),
+	}
+	headers, err := s.fetchHeaders(forkHeight+1, int(height-forkHeight))
+	if err != nil {
+		return err
+	}
+	for _, header := range headers {
+		if err := branch.connect(header); err != nil {
+			return err
+		}
+	}
+	if branch.work(forkHeight+1).Cmp(s.work(forkHeight+1)) < 0 {
+		return fmt.Errorf("indexer chain forking at height %d has less work than the validated chain", forkHeight)
+	}
+
+	s.headers = branch.headers
+	s.hashes = branch.hashes
+	return nil
+}
+
+// work returns the proof of work of the headers from a height
+func (s *HeaderStore) work(fromHeight int32) *big.Int {
+	work := new(big.Int)
+	for _, header := range s.headers[fromHeight-s.startHeight:] {
+		work.Add(work, blockchain.CalcWork(header.Bits))
+	}
+	return work
+}
+
+// fetchHeaders returns up to count consecutive headers of the indexer from a height
+func (s *HeaderStore) fetchHeaders(startHeight int32, count int) ([]wire.BlockHeader, error) {
+	headers := []wire.BlockHeader{}
+	for count > 0 {
+		requested := count
+		if requested > maxHeadersPerRequest {
+			requested = maxHeadersPerRequest
+		}
+		response, err := s.indexerClient.GetBlockHeaders(context.Background(), uint64(startHeight), requested)
+		if err != nil {
+			return nil, err
+		}
+		data, err := hex.DecodeString(response.Hex)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) != response.Count*wire.MaxBlockHeaderPayload {
+			return nil, fmt.Errorf("indexer returned %d bytes for %d headers", len(data), response.Count)
+		}
+		reader := bytes.NewReader(data)
+		for i := 0; i < response.Count; i++ {
+			var header wire.BlockHeader
+			if err := header.Deserialize(reader); err != nil {
+				return nil, err
+			}
+			headers = append(headers, header)
+		}
+		// the indexer chain ends before the requested headers
+		if response.Count < requested {
+			break
+		}
+		startHeight += int32(response.Count)
+		count -= response.Count
+	}
+	return headers, nil
+}
+
+// node returns the validation context of the header at a height, nil if it's not stored
+func (s *HeaderStore) node(height int32) blockchain.HeaderCtx {
+	if height < s.startHeight || height > s.tipHeight() {
+		return nil
+	}
+	return &headerNode{store: s, height: height}
+}
+
+// ChainParams returns the network parameters, part of blockchain.ChainCtx
+func (s *HeaderStore) ChainParams() *chaincfg.Params {
+	return s.params
+}
+
+// BlocksPerRetarget returns the number of blocks between difficulty retargets, part of blockchain.ChainCtx
+func (s *HeaderStore) BlocksPerRetarget() int32 {
+	return int32(s.params.TargetTimespan / s.params.TargetTimePerBlock)
+}
+
+// MinRetargetTimespan returns the minimum timespan of a retarget in seconds, part of blockchain.ChainCtx
+func (s *HeaderStore) MinRetargetTimespan() int64 {
+	return int64(s.params.TargetTimespan/time.Second) / s.params.RetargetAdjustmentFactor
+}
+
+// MaxRetargetTimespan returns the maximum timespan of a retarget in seconds, part of blockchain.ChainCtx
+func (s *HeaderStore) MaxRetargetTimespan() int64 {
+	return int64(s.params.TargetTimespan/time.Second) * s.params.RetargetAdjustmentFactor
+}
+
+// VerifyCheckpoint is part of blockchain.ChainCtx, the chain is validated from the header checkpoint instead
+func (s *HeaderStore) VerifyCheckpoint(int32, *chainhash.Hash) bool {
+	return true
+}
+
+// FindPreviousCheckpoint is part of blockchain.ChainCtx, the chain is validated from the header checkpoint instead
+func (s *HeaderStore) FindPreviousCheckpoint() (blockchain.HeaderCtx, error) {
+	return nil, nil
+}
+
+// headerNode is a stored header as a blockchain.HeaderCtx
+type headerNode struct {
+	store  *HeaderStore
+	height int32
+}
+
+func (n *headerNode) Height() int32 {
+	return n.height
+}
+
+func (n *headerNode) Bits() uint32 {
+	return n.store.headers[n.height-n.store.startHeight].Bits
+}
+
+func (n *headerNode) Timestamp() int64 {
+	return n.store.headers[n.height-n.store.startHeight].Timestamp.Unix()
+}
+
+func (n *headerNode) Parent() blockchain.HeaderCtx {
+	return n.store.node(n.height - 1)
+}
+
+func (n *headerNode) RelativeAncestorCtx(distance int32) blockchain.HeaderCtx {
+	return n.store.node(n.height - distance)
+}
+
+// deserializeBlockHeader decodes a block header hex
+func deserializeBlockHeader(headerHex string) (*wire.BlockHeader, error) {
+	data, err := hex.DecodeString(headerHex)
+	if err != nil {
+		return nil, err
+	}
+	var header wire.BlockHeader
+	if err := header.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
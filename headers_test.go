@@ -0,0 +1,257 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// headerTestIndexer serves the headers of a regtest chain mined by the test
+type headerTestIndexer struct {
+	*mocks.Indexer
+	headers []wire.BlockHeader
+	// tipHex overrides the tip header reported by GetBlockchainInfo
+	tipHex string
+}
+
+func (i *headerTestIndexer) GetBlockHeaders(ctx context.Context, startHeight uint64, count int) (*indexer.BlockHeaders, error) {
+	var buf bytes.Buffer
+	served := 0
+	for height := int(startHeight); height < len(i.headers) && served < count; height++ {
+		if err := i.headers[height].Serialize(&buf); err != nil {
+			return nil, err
+		}
+		served++
+	}
+	return &indexer.BlockHeaders{Count: served, Hex: hex.EncodeToString(buf.Bytes()), Max: maxHeadersPerRequest}, nil
+}
+
+func (i *headerTestIndexer) GetBlockchainInfo(ctx context.Context) (*indexer.BlockChainInfo, error) {
+	if i.tipHex != "" {
+		return &indexer.BlockChainInfo{Height: int32(len(i.headers)) - 1, Hex: i.tipHex}, nil
+	}
+	var buf bytes.Buffer
+	tip := i.headers[len(i.headers)-1]
+	if err := tip.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	return &indexer.BlockChainInfo{Height: int32(len(i.headers)) - 1, Hex: hex.EncodeToString(buf.Bytes())}, nil
+}
+
+// mine appends valid regtest headers up to height, fork distinguishes the headers of competing chains
+func (i *headerTestIndexer) mine(t *testing.T, height int32, fork int32) {
+	for int32(len(i.headers)) <= height {
+		header := wire.BlockHeader{
+			Version:   4,
+			Timestamp: time.Unix(time.Now().Add(-30*24*time.Hour).Unix()+int64(len(i.headers))*600+int64(fork), 0),
+			Bits:      chaincfg.RegressionNetParams.PowLimitBits,
+		}
+		if len(i.headers) > 0 {
+			header.PrevBlock = i.headers[len(i.headers)-1].BlockHash()
+		}
+		grindHeader(t, &header, true)
+		i.headers = append(i.headers, header)
+	}
+}
+
+// grindHeader sets a nonce whose header hash satisfies, or doesn't satisfy, the header target
+func grindHeader(t *testing.T, header *wire.BlockHeader, valid bool) {
+	target := blockchain.CompactToBig(header.Bits)
+	for nonce := uint32(0); nonce < 1000; nonce++ {
+		header.Nonce = nonce
+		hash := header.BlockHash()
+		if (blockchain.HashToBig(&hash).Cmp(target) <= 0) == valid {
+			return
+		}
+	}
+	t.Fatal("no nonce found")
+}
+
+func newHeaderTestIndexer(t *testing.T, height int32) *headerTestIndexer {
+	headerIndexer := &headerTestIndexer{Indexer: new(mocks.Indexer)}
+	headerIndexer.mine(t, height, 0)
+	return headerIndexer
+}
+
+func newTestHeaderStore(headerIndexer *headerTestIndexer, height int32) *HeaderStore {
+	checkpoint := HeaderCheckpoint{Height: height, Hash: headerIndexer.headers[height].BlockHash()}
+	return NewHeaderStore(headerIndexer, &chaincfg.RegressionNetParams, checkpoint)
+}
+
+func TestHeaderStore(t *testing.T) {
+	headerIndexer := newHeaderTestIndexer(t, 2050)
+	store := newTestHeaderStore(headerIndexer, 2030)
+
+	header, err := store.Header(2040)
+	require.NoError(t, err)
+	assert.Equal(t, headerIndexer.headers[2040].BlockHash(), header.BlockHash())
+	assert.EqualValues(t, 2040, store.TipHeight())
+
+	// the trusted headers before the checkpoint are readable down to the last retarget
+	header, err = store.Header(2016)
+	require.NoError(t, err)
+	assert.Equal(t, headerIndexer.headers[2016].BlockHash(), header.BlockHash())
+	_, err = store.Header(2015)
+	assert.ErrorIs(t, err, ErrHeaderBeforeCheckpoint)
+
+	_, err = store.Header(2051)
+	assert.Error(t, err)
+
+	// the indexer reorgs to a longer chain
+	forked := headerIndexer.headers[2035]
+	headerIndexer.headers = headerIndexer.headers[:2035]
+	headerIndexer.mine(t, 2055, 1)
+	header, err = store.Header(2055)
+	require.NoError(t, err)
+	assert.Equal(t, headerIndexer.headers[2055].BlockHash(), header.BlockHash())
+	header, err = store.Header(2035)
+	require.NoError(t, err)
+	assert.NotEqual(t, forked.BlockHash(), header.BlockHash())
+}
+
+func TestHeaderStoreTipSwitch(t *testing.T) {
+	headerIndexer := newHeaderTestIndexer(t, 30)
+	store := newTestHeaderStore(headerIndexer, 20)
+	tipHex := func() string {
+		var buf bytes.Buffer
+		require.NoError(t, headerIndexer.headers[len(headerIndexer.headers)-1].Serialize(&buf))
+		return hex.EncodeToString(buf.Bytes())
+	}
+	require.NoError(t, store.VerifyTip(30, tipHex()))
+
+	// the indexer switches to a competing block of equal work at the same height
+	headerIndexer.headers = headerIndexer.headers[:30]
+	headerIndexer.mine(t, 30, 1)
+	require.NoError(t, store.VerifyTip(30, tipHex()))
+	header, err := store.Header(30)
+	require.NoError(t, err)
+	assert.Equal(t, headerIndexer.headers[30].BlockHash(), header.BlockHash())
+
+	// a lower tip on another branch has less work than the validated chain
+	headerIndexer.headers = headerIndexer.headers[:29]
+	headerIndexer.mine(t, 29, 2)
+	assert.Error(t, store.VerifyTip(29, tipHex()))
+	assert.EqualValues(t, 30, store.TipHeight())
+}
+
+func TestHeaderStoreCheckpointMismatch(t *testing.T) {
+	headerIndexer := newHeaderTestIndexer(t, 30)
+	checkpoint := HeaderCheckpoint{Height: 20, Hash: headerIndexer.headers[21].BlockHash()}
+	store := NewHeaderStore(headerIndexer, &chaincfg.RegressionNetParams, checkpoint)
+	_, err := store.Header(25)
+	assert.ErrorIs(t, err, ErrCheckpointMismatch)
+
+	// the indexer chain forks before the checkpoint
+	store = newTestHeaderStore(headerIndexer, 20)
+	_, err = store.Header(25)
+	require.NoError(t, err)
+	headerIndexer.headers = headerIndexer.headers[:15]
+	headerIndexer.mine(t, 35, 1)
+	_, err = store.Header(35)
+	assert.ErrorIs(t, err, ErrCheckpointMismatch)
+}
+
+func TestHeaderStoreInvalidHeaders(t *testing.T) {
+	testCases := []struct {
+		name   string
+		tamper func(t *testing.T, header *wire.BlockHeader, previous []wire.BlockHeader)
+	}{
+		{
+			name: "not linked",
+			tamper: func(t *testing.T, header *wire.BlockHeader, previous []wire.BlockHeader) {
+				header.PrevBlock = previous[len(previous)-2].BlockHash()
+				grindHeader(t, header, true)
+			},
+		},
+		{
+			name: "insufficient proof of work",
+			tamper: func(t *testing.T, header *wire.BlockHeader, previous []wire.BlockHeader) {
+				grindHeader(t, header, false)
+			},
+		},
+		{
+			name: "unexpected difficulty",
+			tamper: func(t *testing.T, header *wire.BlockHeader, previous []wire.BlockHeader) {
+				header.Bits = 0x207ffffe
+				grindHeader(t, header, true)
+			},
+		},
+		{
+			name: "timestamp not after the median time",
+			tamper: func(t *testing.T, header *wire.BlockHeader, previous []wire.BlockHeader) {
+				header.Timestamp = previous[len(previous)-6].Timestamp
+				grindHeader(t, header, true)
+			},
+		},
+		{
+			name: "timestamp too far in the future",
+			tamper: func(t *testing.T, header *wire.BlockHeader, previous []wire.BlockHeader) {
+				header.Timestamp = time.Unix(time.Now().Add(3*time.Hour).Unix(), 0)
+				grindHeader(t, header, true)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			headerIndexer := newHeaderTestIndexer(t, 25)
+			tc.tamper(t, &headerIndexer.headers[25], headerIndexer.headers[:25])
+			headerIndexer.mine(t, 30, 0)
+			store := newTestHeaderStore(headerIndexer, 20)
+
+			_, err := store.Header(24)
+			require.NoError(t, err)
+			_, err = store.Header(30)
+			assert.Error(t, err)
+			assert.EqualValues(t, 24, store.TipHeight())
+		})
+	}
+}
+
+func TestClientVerifiedHeaders(t *testing.T) {
+	headerIndexer := newHeaderTestIndexer(t, 30)
+	client := &Client{
+		netParams:     &chaincfg.RegressionNetParams,
+		IndexerClient: headerIndexer,
+		headerStore:   newTestHeaderStore(headerIndexer, 20),
+	}
+
+	height, err := client.GetBlockchainHeight()
+	require.NoError(t, err)
+	assert.EqualValues(t, 30, height)
+	header, err := client.GetBlockHeader(28)
+	require.NoError(t, err)
+	assert.Equal(t, headerIndexer.headers[28].BlockHash(), header.BlockHash())
+
+	// the indexer reports a tip that isn't the header it serves
+	headerIndexer.mine(t, 31, 0)
+	var buf bytes.Buffer
+	require.NoError(t, headerIndexer.headers[30].Serialize(&buf))
+	headerIndexer.tipHex = hex.EncodeToString(buf.Bytes())
+	_, err = client.GetBlockchainHeight()
+	assert.Error(t, err)
+}
+
+func TestLoadHeaderCheckpoint(t *testing.T) {
+	checkpoint, err := loadHeaderCheckpoint(&Config{HeaderCheckpointHeight: 10})
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	hash := chaincfg.RegressionNetParams.GenesisHash
+	checkpoint, err = loadHeaderCheckpoint(&Config{HeaderCheckpointHash: hash.String()})
+	require.NoError(t, err)
+	assert.Equal(t, &HeaderCheckpoint{Height: 0, Hash: *hash}, checkpoint)
+
+	_, err = loadHeaderCheckpoint(&Config{HeaderCheckpointHash: "invalid"})
+	assert.Error(t, err)
+}
@@ -15,7 +15,6 @@ import (
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/grail-rollup/btcman/common"
 	"github.com/ledgerwatch/log/v3"
 )
@@ -75,7 +74,8 @@ func NewIndexer(isDebug bool, parentLogger log.Logger) *Indexer {
 func (i *Indexer) Start(serverAddress string) {
 	ctx, _ := signal.NotifyContext(context.Background(), os.Interrupt)
 
-	connectCtx, _ := context.WithTimeout(ctx, time.Second*3)
+	connectCtx, cancel := context.WithTimeout(ctx, time.Second*3)
+	defer cancel()
 	if err := i.connect(connectCtx, serverAddress, nil); err != nil {
 		i.logger.Error("Connect node", "err", err)
 		return
@@ -298,6 +298,32 @@ func (i *Indexer) GetBlockHeader(ctx context.Context, height uint64) (string, er
 	return resp.Result, nil
 }
 
+// GetBlockHeaders returns up to count consecutive block headers from the start height, concatenated in the hex string
+func (i *Indexer) GetBlockHeaders(ctx context.Context, startHeight uint64, count int) (*BlockHeaders, error) {
+	const method string = "blockchain.block.headers"
+	resp := &struct {
+		Result BlockHeaders `json:"result"`
+	}{}
+	err := i.request(ctx, method, []interface{}{startHeight, count}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Result, nil
+}
+
+// GetMerkle returns the merkle branch of a transaction confirmed at a height
+func (i *Indexer) GetMerkle(ctx context.Context, txID string, height int32) (*Merkle, error) {
+	const method string = "blockchain.transaction.get_merkle"
+	resp := &struct {
+		Result Merkle `json:"result"`
+	}{}
+	err := i.request(ctx, method, []interface{}{txID, height}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Result, nil
+}
+
 // GetTransaction returns a transaction from the btc indexer
 func (i *Indexer) GetTransaction(ctx context.Context, txID string, verbose bool) (*btcjson.TxRawResult, error) {
 	if !verbose {
@@ -334,13 +360,13 @@ func (i *Indexer) blockchainTransactionGetNonVerbose(ctx context.Context, txid s
 	return resp.Result, nil
 }
 
-// ListUnspent returns a list of unspent UTXOs by given publicKey
-func (i *Indexer) ListUnspent(ctx context.Context, publicKey *secp256k1.PublicKey) ([]*UTXO, error) {
+// ListUnspent returns a list of unspent UTXOs by given output script
+func (i *Indexer) ListUnspent(ctx context.Context, pkScript []byte) ([]*UTXO, error) {
 	const method string = "blockchain.scripthash.listunspent"
 	resp := &struct {
 		Result []*UTXO `json:"result"`
 	}{}
-	scriptHash, err := publicKeyToScriptHash(publicKey)
+	scriptHash, err := ScriptToScriptHash(pkScript)
 	if err != nil {
 		return nil, err
 	}
@@ -352,13 +378,31 @@ func (i *Indexer) ListUnspent(ctx context.Context, publicKey *secp256k1.PublicKe
 	return resp.Result, nil
 }
 
-// GetHistory return the history of the publicKey
-func (i *Indexer) GetHistory(ctx context.Context, publicKey *secp256k1.PublicKey) ([]*Transaction, error) {
+// GetBalance returns the confirmed and unconfirmed balance of the output script
+func (i *Indexer) GetBalance(ctx context.Context, pkScript []byte) (*Balance, error) {
+	const method string = "blockchain.scripthash.get_balance"
+	resp := &struct {
+		Result Balance `json:"result"`
+	}{}
+	scriptHash, err := ScriptToScriptHash(pkScript)
+	if err != nil {
+		return nil, err
+	}
+	err = i.request(ctx, method, []interface{}{scriptHash}, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp.Result, nil
+}
+
+// GetHistory return the history of the output script
+func (i *Indexer) GetHistory(ctx context.Context, pkScript []byte) ([]*Transaction, error) {
 	const method string = "blockchain.scripthash.get_history"
 	resp := &struct {
 		Result []*Transaction `json:"result"`
 	}{}
-	scriptHash, err := publicKeyToScriptHash(publicKey)
+	scriptHash, err := ScriptToScriptHash(pkScript)
 	if err != nil {
 		return nil, err
 	}
@@ -402,8 +446,11 @@ func (i *Indexer) GetBlockchainInfo(ctx context.Context) (*BlockChainInfo, error
 }
 
 // GetLastInscribedTransactionsByPublicKey returns the txInfos of the last reveal inscription transaction added in a block
-func (i *Indexer) GetLastInscribedTransactionsByPublicKey(ctx context.Context, publicKey *secp256k1.PublicKey, blockchainHeight int32, utxoThreshold float64) ([]*TxInfo, error) {
-	scriptHash, err := publicKeyToScriptHash(publicKey)
+// to the history of the output script, guessing the reveal transactions by their output value.
+//
+// Deprecated: use Client.ScanInscriptions, which identifies the reveal transactions by their envelope
+func (i *Indexer) GetLastInscribedTransactionsByPublicKey(ctx context.Context, pkScript []byte, blockchainHeight int32, utxoThreshold float64) ([]*TxInfo, error) {
+	scriptHash, err := ScriptToScriptHash(pkScript)
 	if err != nil {
 		return nil, err
 	}
@@ -5,17 +5,19 @@ import (
 
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 )
 
 type Indexerer interface {
 	Start(string)
-	ListUnspent(context.Context, *secp256k1.PublicKey) ([]*UTXO, error)
-	GetHistory(context.Context, *secp256k1.PublicKey) ([]*Transaction, error)
+	ListUnspent(context.Context, []byte) ([]*UTXO, error)
+	GetHistory(context.Context, []byte) ([]*Transaction, error)
+	GetBalance(context.Context, []byte) (*Balance, error)
 	GetTransaction(context.Context, string, bool) (*btcjson.TxRawResult, error)
 	GetBlockchainInfo(ctx context.Context) (*BlockChainInfo, error)
 	SendTransaction(ctx context.Context, transactionHex *wire.MsgTx) (string, error)
-	GetLastInscribedTransactionsByPublicKey(ctx context.Context, publicKey *secp256k1.PublicKey, blockchainHeight int32, utxoThreshold float64) ([]*TxInfo, error)
+	GetLastInscribedTransactionsByPublicKey(ctx context.Context, pkScript []byte, blockchainHeight int32, utxoThreshold float64) ([]*TxInfo, error)
 	GetBlockHeader(ctx context.Context, height uint64) (string, error)
+	GetBlockHeaders(ctx context.Context, startHeight uint64, count int) (*BlockHeaders, error)
+	GetMerkle(ctx context.Context, txID string, height int32) (*Merkle, error)
 	Disconnect()
 }
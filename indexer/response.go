@@ -10,6 +10,13 @@ type UTXO struct {
 	Value  int64  `json:"value"`
 	TxHash string `json:"tx_hash"`
 	Height int    `json:"height"`
+	// PkScript is the output script the utxo was listed by, it is not part of the indexer response
+	PkScript []byte `json:"-"`
+}
+
+type Balance struct {
+	Confirmed   int64 `json:"confirmed"`
+	Unconfirmed int64 `json:"unconfirmed"`
 }
 
 type BlockChainInfo struct {
@@ -17,6 +24,18 @@ type BlockChainInfo struct {
 	Hex    string `json:"hex"`
 }
 
+type BlockHeaders struct {
+	Count int    `json:"count"`
+	Hex   string `json:"hex"`
+	Max   int    `json:"max"`
+}
+
+type Merkle struct {
+	BlockHeight int32    `json:"block_height"`
+	Merkle      []string `json:"merkle"`
+	Pos         int      `json:"pos"`
+}
+
 type TxInfo struct {
 	Height int32  `json:"height"`
 	TxHash string `json:"tx_hash"`
@@ -6,11 +6,12 @@ import (
 	"encoding/hex"
 	"fmt"
 
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
 	"github.com/btcsuite/btcd/btcutil"
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
-	"golang.org/x/crypto/ripemd160"
 )
 
 // PrivateKeyToPublicKey returns a public key string by a given private key
@@ -35,20 +36,56 @@ func PublicKeyToAddress(publicKey *secp256k1.PublicKey, network *chaincfg.Params
 	return address, nil
 }
 
-// publicKeyToScriptHash returns a script hash by a given public key
-func publicKeyToScriptHash(publicKey *secp256k1.PublicKey) (string, error) {
-	publicKeyStr := hex.EncodeToString(publicKey.SerializeCompressed())
-	sha256Hashed, err := calculateSHA256(publicKeyStr)
+// PublicKeyToLegacyAddress returns a P2PKH address by a given public key
+func PublicKeyToLegacyAddress(publicKey *secp256k1.PublicKey, network *chaincfg.Params) (btcutil.Address, error) {
+	publicKeyHash := btcutil.Hash160(publicKey.SerializeCompressed())
+	address, err := btcutil.NewAddressPubKeyHash(publicKeyHash, network)
 	if err != nil {
-		return "", fmt.Errorf("error hashing public key with sha256: %s", err)
+		return nil, fmt.Errorf("failed to generate P2PKH address: %v", err)
 	}
-	pkHash := calculateRIPEMD160(sha256Hashed)
 
-	pubScript := fmt.Sprintf("0014%s", hex.EncodeToString(pkHash))
+	return address, nil
+}
+
+// PublicKeyToNestedSegwitAddress returns a P2SH-P2WPKH address by a given public key
+func PublicKeyToNestedSegwitAddress(publicKey *secp256k1.PublicKey, network *chaincfg.Params) (btcutil.Address, error) {
+	witnessAddress, err := PublicKeyToAddress(publicKey, network)
+	if err != nil {
+		return nil, err
+	}
+	redeemScript, err := txscript.PayToAddrScript(witnessAddress)
+	if err != nil {
+		return nil, err
+	}
+	address, err := btcutil.NewAddressScriptHash(redeemScript, network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate P2SH-P2WPKH address: %v", err)
+	}
+
+	return address, nil
+}
 
+// PublicKeyToTaprootAddress returns a key path only (BIP86) taproot address by a given public key
+func PublicKeyToTaprootAddress(publicKey *secp256k1.PublicKey, network *chaincfg.Params) (btcutil.Address, error) {
+	outputKey := txscript.ComputeTaprootKeyNoScript(publicKey)
+	address, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate P2TR address: %v", err)
+	}
+
+	return address, nil
+}
+
+// ScriptToScriptHash returns the electrum script hash of a given output script
+func ScriptToScriptHash(pkScript []byte) (string, error) {
+	return scriptToScriptHash(hex.EncodeToString(pkScript))
+}
+
+// scriptToScriptHash returns the electrum script hash of a given hex encoded output script
+func scriptToScriptHash(pubScript string) (string, error) {
 	scriptHash, err := calculateSHA256(pubScript)
 	if err != nil {
-		return "", fmt.Errorf("error hashing public key with sha256: %s", err)
+		return "", fmt.Errorf("error hashing script with sha256: %s", err)
 	}
 
 	bigEndianBytes := make([]byte, len(scriptHash))
@@ -72,15 +109,6 @@ func calculateSHA256(data string) ([]byte, error) {
 	return sum, nil
 }
 
-// calculateRIPEMD160 returns a hash of data, using ripemd160 hashing function
-func calculateRIPEMD160(data []byte) []byte {
-	hash := ripemd160.New()
-	hash.Write(data)
-	sum := hash.Sum(nil)
-
-	return sum
-}
-
 // convertEndianess changes the endianes of the provided data
 func convertEndianess(src []byte, dst []byte) error {
 	if len(src) != len(dst) {
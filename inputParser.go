@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 )
 
 func loadNetwork(networkInput string) (*chaincfg.Params, error) {
@@ -30,6 +31,30 @@ func loadMode(modeInput string) (BtcmanMode, error) {
 	}
 }
 
+func loadAddressType(addressTypeInput string) (AddressType, error) {
+	switch addressTypeInput {
+	case "p2pkh":
+		return P2PKHAddress, nil
+	case "p2sh-p2wpkh":
+		return P2SHP2WPKHAddress, nil
+	case "", "p2wpkh":
+		return P2WPKHAddress, nil
+	case "p2tr":
+		return P2TRAddress, nil
+	case "p2wsh":
+		return P2WSHAddress, nil
+	default:
+		return InvalidAddress, errors.New("invalid address type")
+	}
+}
+
+func loadCoinSelector(coinSelectionInput string) (CoinSelector, error) {
+	if coinSelectionInput == "" {
+		return NewCoinSelector(BranchAndBound)
+	}
+	return NewCoinSelector(CoinSelectionStrategy(coinSelectionInput))
+}
+
 func loadConsolidationValues(cfg *Config) (consolidationInterval, consolidationTransactionFee, utxoThreshold, minUtxoConsolidationAmount int) {
 	if consolidationInterval = cfg.ConsolidationInterval; consolidationInterval == 0 {
 		consolidationInterval = DEFAULT_CONSOLIDATION_INTERVAL
@@ -46,3 +71,37 @@ func loadConsolidationValues(cfg *Config) (consolidationInterval, consolidationT
 
 	return consolidationInterval, consolidationTransactionFee, utxoThreshold, minUtxoConsolidationAmount
 }
+
+func loadConfirmationPolicy(cfg *Config, network *chaincfg.Params) ConfirmationPolicy {
+	policy := ConfirmationPolicy{
+		Spendable: DEFAULT_SPENDABLE_CONFIRMATIONS,
+		Coinbase:  int64(cfg.CoinbaseConfirmations),
+		Final:     int64(cfg.FinalConfirmations),
+	}
+	// 0 spendable confirmations is a valid policy, only a missing value takes the default
+	if cfg.SpendableConfirmations != nil {
+		policy.Spendable = int64(*cfg.SpendableConfirmations)
+	}
+	if policy.Coinbase == 0 {
+		policy.Coinbase = int64(network.CoinbaseMaturity)
+	}
+	if policy.Final == 0 {
+		policy.Final = DEFAULT_FINAL_CONFIRMATIONS
+	}
+	return policy
+}
+
+// loadHeaderCheckpoint returns the checkpoint the block headers are verified from, nil if no checkpoint hash is configured
+func loadHeaderCheckpoint(cfg *Config) (*HeaderCheckpoint, error) {
+	if cfg.HeaderCheckpointHash == "" {
+		return nil, nil
+	}
+	if cfg.HeaderCheckpointHeight < 0 {
+		return nil, errors.New("invalid header checkpoint height")
+	}
+	hash, err := chainhash.NewHashFromStr(cfg.HeaderCheckpointHash)
+	if err != nil {
+		return nil, errors.New("invalid header checkpoint hash")
+	}
+	return &HeaderCheckpoint{Height: int32(cfg.HeaderCheckpointHeight), Hash: *hash}, nil
+}
@@ -1,7 +1,11 @@
 package btcman
 
 import (
+	"context"
+
 	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/grail-rollup/btcman/indexer"
@@ -10,16 +14,33 @@ import (
 // Clienter is the interface for creating inscriptions in a btc transaction
 type Clienter interface {
 	Inscribe(data []byte) error
-	DecodeInscription(revealTxHash string) (string, error)
+	InscribeWithOptions(data []byte, opts InscribeOptions) (*InscribeResult, error)
+	InscribeBatch(payloads [][]byte, opts InscribeOptions) (*InscribeResult, error)
+	EstimateInscription(data []byte, opts InscribeOptions) (*InscriptionEstimate, error)
+	DecodeInscription(revealTxHash string) (*Inscription, error)
+	DecodeInscriptions(revealTxHash string) ([]*Inscription, error)
+	DecodeChunkedInscription(firstRevealTxHash string) ([]byte, error)
+	DecodeOpReturnData(txHash string) ([]byte, error)
+	ScanInscriptions(startHeight int32, handle func(*ScannedInscription) error) error
 	GetBlockchainHeight() (int32, error)
 	ListUnspent() ([]*indexer.UTXO, error)
+	GetBalance() (*Balance, error)
+	GetWalletSummary(samplePayload []byte) (*WalletSummary, error)
+	Send(outputs []Output, feeRate int64) (*TxEstimate, error)
 	GetHistory(startHeight int, includeMempool bool) ([]*indexer.Transaction, error)
 	GetTransaction(txid string, verbose bool) (*btcjson.TxRawResult, error)
 	GetBlockHeader(height uint64) (*wire.BlockHeader, error)
+	GetMerkleProof(txid string, height int32) (*MerkleProof, error)
+	WaitForConfirmations(ctx context.Context, txid string, confirmations int64) error
+	CheckConflicts(tx *wire.MsgTx) error
 	Shutdown()
 }
 
 type Keychainer interface {
-	SignTransaction(rawTransaction *wire.MsgTx, indexer indexer.Indexerer) error
+	SignTransaction(rawTransaction *wire.MsgTx, prevOutFetcher txscript.PrevOutputFetcher) error
+	// GetPublicKey returns the signing key of the keychain, nil if it has none, e.g. a multisig keychain in reader mode
 	GetPublicKey() *secp256k1.PublicKey
+	GetAddress() btcutil.Address
+	GetPkScript() []byte
+	GetPkScripts() [][]byte
 }
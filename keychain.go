@@ -1,7 +1,7 @@
 package btcman
 
 import (
-	"context"
+	"bytes"
 	"encoding/hex"
 	"fmt"
 
@@ -18,15 +18,17 @@ import (
 
 // keychain represents an agglomeration of the keys used inside the btcman and btc indexer
 type keychain struct {
-	mode          BtcmanMode
-	privateKeyWIF string
-	privateKey    *secp256k1.PrivateKey
-	publicKey     *secp256k1.PublicKey
-	network       *chaincfg.Params
-	logger        log.Logger
+	mode        BtcmanMode
+	addressType AddressType
+	privateKey  *secp256k1.PrivateKey
+	publicKey   *secp256k1.PublicKey
+	address     btcutil.Address
+	pkScripts   map[AddressType][]byte
+	network     *chaincfg.Params
+	logger      log.Logger
 }
 
-func NewKeychain(cfg *Config, mode BtcmanMode, network *chaincfg.Params, parentLogger log.Logger) (Keychainer, error) {
+func NewKeychain(cfg *Config, mode BtcmanMode, addressType AddressType, network *chaincfg.Params, parentLogger log.Logger) (Keychainer, error) {
 	var privateKey *secp256k1.PrivateKey
 	var publicKey *secp256k1.PublicKey
 	keychainLogger := parentLogger.New("module", common.KEYCHAIN)
@@ -55,69 +57,149 @@ func NewKeychain(cfg *Config, mode BtcmanMode, network *chaincfg.Params, parentL
 		}
 	}
 
+	address, err := publicKeyToAddress(publicKey, addressType, network)
+	if err != nil {
+		return nil, err
+	}
+	pkScripts, err := publicKeyToPkScripts(publicKey, network)
+	if err != nil {
+		return nil, err
+	}
+
 	return &keychain{
-		mode:          mode,
-		privateKeyWIF: cfg.PrivateKey,
-		publicKey:     publicKey,
-		privateKey:    privateKey,
-		network:       network,
-		logger:        keychainLogger,
+		mode:        mode,
+		addressType: addressType,
+		publicKey:   publicKey,
+		privateKey:  privateKey,
+		address:     address,
+		pkScripts:   pkScripts,
+		network:     network,
+		logger:      keychainLogger,
 	}, nil
 }
 
-// SignTransaction signs a provided unsigned transaction, indexer is used for retrieving the necessary information about previous transactions
-func (k *keychain) SignTransaction(rawTransaction *wire.MsgTx, indexer indexer.Indexerer) error {
+// publicKeyToAddress returns the wallet address of a public key for the given address type
+func publicKeyToAddress(publicKey *secp256k1.PublicKey, addressType AddressType, network *chaincfg.Params) (btcutil.Address, error) {
+	switch addressType {
+	case P2PKHAddress:
+		return indexer.PublicKeyToLegacyAddress(publicKey, network)
+	case P2SHP2WPKHAddress:
+		return indexer.PublicKeyToNestedSegwitAddress(publicKey, network)
+	case P2WPKHAddress:
+		return indexer.PublicKeyToAddress(publicKey, network)
+	case P2TRAddress:
+		return indexer.PublicKeyToTaprootAddress(publicKey, network)
+	default:
+		return nil, fmt.Errorf("unsupported address type %s", addressType)
+	}
+}
+
+// publicKeyToPkScripts returns the output scripts of every address type spendable by the public key
+func publicKeyToPkScripts(publicKey *secp256k1.PublicKey, network *chaincfg.Params) (map[AddressType][]byte, error) {
+	pkScripts := make(map[AddressType][]byte, len(spendableAddressTypes))
+	for _, addressType := range spendableAddressTypes {
+		address, err := publicKeyToAddress(publicKey, addressType, network)
+		if err != nil {
+			return nil, err
+		}
+		pkScript, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			return nil, err
+		}
+		pkScripts[addressType] = pkScript
+	}
+	return pkScripts, nil
+}
+
+// SignTransaction signs a provided unsigned transaction, prevOutFetcher provides the outputs spent by the inputs
+func (k *keychain) SignTransaction(rawTransaction *wire.MsgTx, prevOutFetcher txscript.PrevOutputFetcher) error {
 	if k.mode == ReaderMode {
 		return fmt.Errorf("btcman in reader mode does not support signing transactions")
 	}
 
-	for idx, txInput := range rawTransaction.TxIn {
+	prevOuts, err := fetchPrevOuts(rawTransaction, prevOutFetcher)
+	if err != nil {
+		return err
+	}
+	sigHashes := txscript.NewTxSigHashes(rawTransaction, prevOutFetcher)
 
-		prevTx, err := indexer.GetTransaction(context.Background(), txInput.PreviousOutPoint.Hash.String(), true)
+	for idx, prevOut := range prevOuts {
+		err = k.signInput(rawTransaction, idx, prevOut.Value, prevOut.PkScript, sigHashes)
 		if err != nil {
 			return err
 		}
+	}
+	k.logger.Info("Transaction signed successfully")
+	return nil
+}
+
+// signInput is a helper for SignTransaction that sets the signature script and witness of an input
+// according to the class of the spent output script
+func (k *keychain) signInput(tx *wire.MsgTx, idx int, amt int64, subscript []byte, sigHashes *txscript.TxSigHashes) error {
+	addressType, ok := k.getAddressType(subscript)
+	if !ok {
+		return fmt.Errorf("input %d spends a %s output not controlled by the keychain", idx, txscript.GetScriptClass(subscript))
+	}
 
-		subscript, err := hex.DecodeString(prevTx.Vout[txInput.PreviousOutPoint.Index].ScriptPubKey.Hex)
+	txIn := tx.TxIn[idx]
+	switch addressType {
+	case P2PKHAddress:
+		signatureScript, err := txscript.SignatureScript(tx, idx, subscript, txscript.SigHashAll, k.privateKey, true)
+		if err != nil {
+			return fmt.Errorf("failed to sign transaction: %v", err)
+		}
+		txIn.SignatureScript = signatureScript
+	case P2SHP2WPKHAddress:
+		// the redeem script is the P2WPKH script of the key, the signature is produced against it
+		redeemScript := k.pkScripts[P2WPKHAddress]
+		signatureScript, err := txscript.NewScriptBuilder().AddData(redeemScript).Script()
 		if err != nil {
 			return err
 		}
-
-		amount := int64(prevTx.Vout[txInput.PreviousOutPoint.Index].Value * btcutil.SatoshiPerBitcoin)
-
-		signature, err := k.generateSignature(rawTransaction, idx, amount, subscript, indexer)
+		witness, err := k.generateSignature(tx, idx, amt, redeemScript, addressType, sigHashes)
 		if err != nil {
 			return err
 		}
-
-		txInput.Witness = signature
+		txIn.SignatureScript = signatureScript
+		txIn.Witness = witness
+	default:
+		witness, err := k.generateSignature(tx, idx, amt, subscript, addressType, sigHashes)
+		if err != nil {
+			return err
+		}
+		txIn.Witness = witness
 	}
-	k.logger.Info("Transaction signed successfully")
 	return nil
 }
 
-// generateSignature is a helper for SignTransaction that generates the actual signatures
-func (k *keychain) generateSignature(tx *wire.MsgTx, idx int, amt int64, subscript []byte, indexer indexer.Indexerer) (wire.TxWitness, error) {
-	prevOutFetcher := NewPreviousOutPointFetcher(indexer, k.logger)
-
-	wifKey, err := btcutil.DecodeWIF(k.privateKeyWIF)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode WIF: %v", err)
-	}
-
-	privKey := wifKey.PrivKey
-	sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
-
-	signature, err := txscript.WitnessSignature(
-		tx,
-		sigHashes,
-		idx,
-		amt,
-		subscript,
-		txscript.SigHashAll,
-		privKey,
-		true,
-	)
+// generateSignature is a helper for signInput that generates the witness of segwit inputs
+func (k *keychain) generateSignature(tx *wire.MsgTx, idx int, amt int64, subscript []byte, addressType AddressType, sigHashes *txscript.TxSigHashes) (wire.TxWitness, error) {
+	var signature wire.TxWitness
+	var err error
+	switch addressType {
+	case P2TRAddress:
+		// key path spend, the private key is tweaked with an empty script root as in BIP86
+		signature, err = txscript.TaprootWitnessSignature(
+			tx,
+			sigHashes,
+			idx,
+			amt,
+			subscript,
+			txscript.SigHashDefault,
+			k.privateKey,
+		)
+	default:
+		signature, err = txscript.WitnessSignature(
+			tx,
+			sigHashes,
+			idx,
+			amt,
+			subscript,
+			txscript.SigHashAll,
+			k.privateKey,
+			true,
+		)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign transaction: %v", err)
 	}
@@ -125,7 +207,81 @@ func (k *keychain) generateSignature(tx *wire.MsgTx, idx int, amt int64, subscri
 	return signature, nil
 }
 
+// getAddressType returns the address type of an output script controlled by the keychain
+func (k *keychain) getAddressType(pkScript []byte) (AddressType, bool) {
+	for addressType, script := range k.pkScripts {
+		if bytes.Equal(script, pkScript) {
+			return addressType, true
+		}
+	}
+	return InvalidAddress, false
+}
+
 // GetPublicKey returns the public key as string
 func (k *keychain) GetPublicKey() *secp256k1.PublicKey {
 	return k.publicKey
 }
+
+// GetAddress returns the wallet address derived from the public key
+func (k *keychain) GetAddress() btcutil.Address {
+	return k.address
+}
+
+// GetPkScript returns the output script of the wallet address
+func (k *keychain) GetPkScript() []byte {
+	return k.pkScripts[k.addressType]
+}
+
+// GetPkScripts returns the output scripts of all the addresses spendable by the keychain, starting with the wallet address
+func (k *keychain) GetPkScripts() [][]byte {
+	pkScripts := [][]byte{k.pkScripts[k.addressType]}
+	for _, addressType := range spendableAddressTypes {
+		if addressType != k.addressType {
+			pkScripts = append(pkScripts, k.pkScripts[addressType])
+		}
+	}
+	return pkScripts
+}
+
+// estimationKeychain signs transactions with the witness templates of a keychain instead of reaching its signers,
+// e.g. remote cosigners or musig2 participants. The signed transactions have their final size and txid but can't be sent
+type estimationKeychain struct {
+	Keychainer
+	templater witnessTemplater
+}
+
+// SignTransaction sets the template witness of every input
+func (k *estimationKeychain) SignTransaction(rawTransaction *wire.MsgTx, prevOutFetcher txscript.PrevOutputFetcher) error {
+	prevOuts, err := fetchPrevOuts(rawTransaction, prevOutFetcher)
+	if err != nil {
+		return err
+	}
+	for idx, txIn := range rawTransaction.TxIn {
+		witness, ok := k.templater.witnessTemplate(prevOuts[idx].PkScript)
+		if !ok {
+			return fmt.Errorf("no witness template for the output script of input %d", idx)
+		}
+		txIn.Witness = witness
+	}
+	return nil
+}
+
+// witnessTemplate returns the witness template of the wrapped keychain
+func (k *estimationKeychain) witnessTemplate(pkScript []byte) (wire.TxWitness, bool) {
+	return k.templater.witnessTemplate(pkScript)
+}
+
+// dryRunKeychain returns the keychain signing the transactions of estimates and dry runs,
+// the keychains with witness templates don't reach their signers and the others sign as usual
+func (client *Client) dryRunKeychain() Keychainer {
+	if templater, ok := client.keychain.(witnessTemplater); ok {
+		return &estimationKeychain{Keychainer: client.keychain, templater: templater}
+	}
+	return client.keychain
+}
+
+// dryRunSigned returns true if the dry runs are signed by the keychain, false if they get witness templates
+func (client *Client) dryRunSigned() bool {
+	_, ok := client.keychain.(witnessTemplater)
+	return !ok
+}
@@ -0,0 +1,402 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/grail-rollup/btcman/common"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// unspendableInternalKey is the BIP341 NUMS point used as taproot internal key, it disables the key path spend
+const unspendableInternalKey = "50929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac0"
+
+// CosignRequest is the data a cosigner needs in order to sign the inputs of a multisig transaction
+type CosignRequest struct {
+	Tx            *wire.MsgTx
+	PrevOuts      map[wire.OutPoint]*wire.TxOut
+	WitnessScript []byte
+	AddressType   AddressType
+}
+
+// Cosigner produces the signatures of a single multisig participant, it can be local or remote
+type Cosigner interface {
+	GetPublicKey() *secp256k1.PublicKey
+	// Sign returns a signature for every input of the requested transaction
+	Sign(ctx context.Context, request *CosignRequest) ([][]byte, error)
+}
+
+// localCosigner is a cosigner holding its private key in the process
+type localCosigner struct {
+	privateKey *secp256k1.PrivateKey
+}
+
+func NewLocalCosigner(privateKey *secp256k1.PrivateKey) Cosigner {
+	return &localCosigner{
+		privateKey: privateKey,
+	}
+}
+
+// GetPublicKey returns the public key of the cosigner
+func (c *localCosigner) GetPublicKey() *secp256k1.PublicKey {
+	return c.privateKey.PubKey()
+}
+
+// Sign returns the signatures of all the inputs of the requested transaction
+func (c *localCosigner) Sign(ctx context.Context, request *CosignRequest) ([][]byte, error) {
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(request.PrevOuts)
+	prevOuts, err := fetchPrevOuts(request.Tx, prevOutFetcher)
+	if err != nil {
+		return nil, err
+	}
+	sigHashes := txscript.NewTxSigHashes(request.Tx, prevOutFetcher)
+
+	signatures := make([][]byte, len(prevOuts))
+	for idx, prevOut := range prevOuts {
+		switch request.AddressType {
+		case P2WSHAddress:
+			signatures[idx], err = txscript.RawTxInWitnessSignature(request.Tx, sigHashes, idx, prevOut.Value,
+				request.WitnessScript, txscript.SigHashAll, c.privateKey)
+		case P2TRAddress:
+			signatures[idx], err = txscript.RawTxInTapscriptSignature(request.Tx, sigHashes, idx, prevOut.Value,
+				prevOut.PkScript, txscript.NewBaseTapLeaf(request.WitnessScript), txscript.SigHashDefault, c.privateKey)
+		default:
+			err = fmt.Errorf("unsupported multisig address type %s", request.AddressType)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return signatures, nil
+}
+
+// multisigKeychain is a threshold keychain where the wallet output is a m-of-n multisig script
+type multisigKeychain struct {
+	mode                BtcmanMode
+	addressType         AddressType
+	threshold           int
+	publicKeys          []*secp256k1.PublicKey
+	localPublicKey      *secp256k1.PublicKey
+	cosigners           []Cosigner
+	witnessScript       []byte
+	controlBlockWitness []byte
+	address             btcutil.Address
+	pkScript            []byte
+	network             *chaincfg.Params
+	logger              log.Logger
+}
+
+// NewMultisigKeychain creates a multisig keychain from the configured public keys and threshold. In writer mode
+// the configured private key is used as local cosigner, the signatures of the other participants are collected from cosigners.
+// The address type is either p2wsh or p2tr, the later is a taproot script path spend with an unspendable internal key.
+// In writer mode the cosigners must be enough to reach the threshold.
+func NewMultisigKeychain(cfg *Config, mode BtcmanMode, addressType AddressType, network *chaincfg.Params, parentLogger log.Logger, cosigners ...Cosigner) (Keychainer, error) {
+	keychainLogger := parentLogger.New("module", common.KEYCHAIN)
+
+	if len(cfg.MultisigPublicKeys) == 0 {
+		return nil, fmt.Errorf("multisig public keys are required for a multisig keychain")
+	}
+	if cfg.MultisigThreshold < 1 || cfg.MultisigThreshold > len(cfg.MultisigPublicKeys) {
+		return nil, fmt.Errorf("invalid multisig threshold %d for %d public keys", cfg.MultisigThreshold, len(cfg.MultisigPublicKeys))
+	}
+
+	publicKeys := make([]*secp256k1.PublicKey, len(cfg.MultisigPublicKeys))
+	for i, publicKeyHex := range cfg.MultisigPublicKeys {
+		publicKeyBytes, err := hex.DecodeString(publicKeyHex)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding multisig public key %d", i)
+		}
+		publicKeys[i], err = secp256k1.ParsePubKey(publicKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding multisig public key %d", i)
+		}
+	}
+	// keys are sorted as in BIP67 so every participant derives the same script
+	sort.Slice(publicKeys, func(i, j int) bool {
+		return bytes.Compare(publicKeys[i].SerializeCompressed(), publicKeys[j].SerializeCompressed()) < 0
+	})
+
+	var localPublicKey *secp256k1.PublicKey
+	if mode == WriterMode {
+		if cfg.PrivateKey == "" {
+			return nil, fmt.Errorf("private key is required for btcman in writer mode")
+		}
+		wif, err := btcutil.DecodeWIF(cfg.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding wif private key")
+		}
+		localPublicKey = wif.PrivKey.PubKey()
+		cosigners = append([]Cosigner{NewLocalCosigner(wif.PrivKey)}, cosigners...)
+	}
+	participants := make(map[int]bool, len(cosigners))
+	for _, cosigner := range cosigners {
+		if cosigner.GetPublicKey() == nil {
+			return nil, fmt.Errorf("cosigner has no public key")
+		}
+		keyIndex := multisigKeyIndex(publicKeys, cosigner.GetPublicKey())
+		if keyIndex == -1 {
+			return nil, fmt.Errorf("cosigner %x is not a multisig participant", cosigner.GetPublicKey().SerializeCompressed())
+		}
+		participants[keyIndex] = true
+	}
+	if mode == WriterMode && len(participants) < cfg.MultisigThreshold {
+		return nil, fmt.Errorf("multisig threshold %d can't be met by %d cosigners", cfg.MultisigThreshold, len(participants))
+	}
+
+	k := &multisigKeychain{
+		mode:           mode,
+		addressType:    addressType,
+		threshold:      cfg.MultisigThreshold,
+		publicKeys:     publicKeys,
+		localPublicKey: localPublicKey,
+		cosigners:      cosigners,
+		network:        network,
+		logger:         keychainLogger,
+	}
+
+	var err error
+	switch addressType {
+	case P2WSHAddress:
+		err = k.buildWitnessScriptHash()
+	case P2TRAddress:
+		err = k.buildTapscript()
+	default:
+		err = fmt.Errorf("unsupported multisig address type %s", addressType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	k.pkScript, err = txscript.PayToAddrScript(k.address)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// buildWitnessScriptHash creates the m-of-n OP_CHECKMULTISIG witness script and its P2WSH address
+func (k *multisigKeychain) buildWitnessScriptHash() error {
+	addressPublicKeys := make([]*btcutil.AddressPubKey, len(k.publicKeys))
+	for i, publicKey := range k.publicKeys {
+		addressPublicKey, err := btcutil.NewAddressPubKey(publicKey.SerializeCompressed(), k.network)
+		if err != nil {
+			return err
+		}
+		addressPublicKeys[i] = addressPublicKey
+	}
+	witnessScript, err := txscript.MultiSigScript(addressPublicKeys, k.threshold)
+	if err != nil {
+		return err
+	}
+	scriptHash := sha256.Sum256(witnessScript)
+	address, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], k.network)
+	if err != nil {
+		return fmt.Errorf("failed to generate P2WSH address: %v", err)
+	}
+
+	k.witnessScript = witnessScript
+	k.address = address
+	return nil
+}
+
+// buildTapscript creates the m-of-n OP_CHECKSIGADD tapscript and its taproot address
+func (k *multisigKeychain) buildTapscript() error {
+	builder := txscript.NewScriptBuilder()
+	for i, publicKey := range k.publicKeys {
+		builder.AddData(schnorr.SerializePubKey(publicKey))
+		if i == 0 {
+			builder.AddOp(txscript.OP_CHECKSIG)
+		} else {
+			builder.AddOp(txscript.OP_CHECKSIGADD)
+		}
+	}
+	builder.AddInt64(int64(k.threshold)).AddOp(txscript.OP_NUMEQUAL)
+	tapscript, err := builder.Script()
+	if err != nil {
+		return err
+	}
+
+	internalKeyBytes, err := hex.DecodeString(unspendableInternalKey)
+	if err != nil {
+		return err
+	}
+	internalKey, err := schnorr.ParsePubKey(internalKeyBytes)
+	if err != nil {
+		return err
+	}
+
+	leafNode := txscript.NewBaseTapLeaf(tapscript)
+	proof := &txscript.TapscriptProof{
+		TapLeaf:  leafNode,
+		RootNode: leafNode,
+	}
+	controlBlock := proof.ToControlBlock(internalKey)
+	controlBlockWitness, err := controlBlock.ToBytes()
+	if err != nil {
+		return err
+	}
+
+	tapHash := proof.RootNode.TapHash()
+	outputKey := txscript.ComputeTaprootOutputKey(internalKey, tapHash[:])
+	address, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), k.network)
+	if err != nil {
+		return fmt.Errorf("failed to generate P2TR address: %v", err)
+	}
+
+	k.witnessScript = tapscript
+	k.controlBlockWitness = controlBlockWitness
+	k.address = address
+	return nil
+}
+
+// SignTransaction collects the signatures of the cosigners and finalizes the witnesses once the threshold is reached,
+// prevOutFetcher provides the outputs spent by the inputs
+func (k *multisigKeychain) SignTransaction(rawTransaction *wire.MsgTx, prevOutFetcher txscript.PrevOutputFetcher) error {
+	if k.mode == ReaderMode {
+		return fmt.Errorf("btcman in reader mode does not support signing transactions")
+	}
+
+	prevOutList, err := fetchPrevOuts(rawTransaction, prevOutFetcher)
+	if err != nil {
+		return err
+	}
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut, len(rawTransaction.TxIn))
+	for idx, txInput := range rawTransaction.TxIn {
+		if !bytes.Equal(prevOutList[idx].PkScript, k.pkScript) {
+			return fmt.Errorf("input %d spends an output not controlled by the multisig keychain", idx)
+		}
+		prevOuts[txInput.PreviousOutPoint] = prevOutList[idx]
+	}
+
+	signatures, err := k.collectSignatures(&CosignRequest{
+		Tx:            rawTransaction,
+		PrevOuts:      prevOuts,
+		WitnessScript: k.witnessScript,
+		AddressType:   k.addressType,
+	})
+	if err != nil {
+		return err
+	}
+
+	for idx, txInput := range rawTransaction.TxIn {
+		txInput.Witness = k.finalizeWitness(signatures[idx])
+	}
+	k.logger.Info("Multisig transaction signed successfully")
+	return nil
+}
+
+// collectSignatures requests signatures from the cosigners until the threshold is reached, the result holds
+// the signatures of every input indexed by the position of the cosigner key in the script
+func (k *multisigKeychain) collectSignatures(request *CosignRequest) ([]map[int][]byte, error) {
+	signatures := make([]map[int][]byte, len(request.Tx.TxIn))
+	for idx := range signatures {
+		signatures[idx] = make(map[int][]byte, k.threshold)
+	}
+
+	collected := make(map[int]bool, k.threshold)
+	for _, cosigner := range k.cosigners {
+		if len(collected) == k.threshold {
+			break
+		}
+		keyIndex := multisigKeyIndex(k.publicKeys, cosigner.GetPublicKey())
+		if collected[keyIndex] {
+			continue
+		}
+		cosignerSignatures, err := cosigner.Sign(context.Background(), request)
+		if err == nil && len(cosignerSignatures) != len(request.Tx.TxIn) {
+			err = fmt.Errorf("returned %d signatures for %d inputs", len(cosignerSignatures), len(request.Tx.TxIn))
+		}
+		if err != nil {
+			k.logger.Warn("Cosigner failed to sign", "publicKey", hex.EncodeToString(cosigner.GetPublicKey().SerializeCompressed()), "err", err)
+			continue
+		}
+		for idx, signature := range cosignerSignatures {
+			signatures[idx][keyIndex] = signature
+		}
+		collected[keyIndex] = true
+	}
+	if len(collected) < k.threshold {
+		return nil, fmt.Errorf("collected %d of %d required signatures", len(collected), k.threshold)
+	}
+	return signatures, nil
+}
+
+// finalizeWitness builds the input witness from the collected signatures
+func (k *multisigKeychain) finalizeWitness(signatures map[int][]byte) wire.TxWitness {
+	if k.addressType == P2TRAddress {
+		// OP_CHECKSIG of the first key consumes the top of the stack, the keys are pushed in reverse order
+		// and the missing signatures are left empty
+		witness := make(wire.TxWitness, 0, len(k.publicKeys)+2)
+		for i := len(k.publicKeys) - 1; i >= 0; i-- {
+			witness = append(witness, signatures[i])
+		}
+		return append(witness, k.witnessScript, k.controlBlockWitness)
+	}
+
+	// the dummy element is required by the OP_CHECKMULTISIG off-by-one bug
+	witness := wire.TxWitness{nil}
+	for i := range k.publicKeys {
+		if signature, ok := signatures[i]; ok {
+			witness = append(witness, signature)
+		}
+	}
+	return append(witness, k.witnessScript)
+}
+
+// witnessTemplate returns a witness of the size of a signed input spending pkScript,
+// the signatures are placeholders of the maximum signature size
+func (k *multisigKeychain) witnessTemplate(pkScript []byte) (wire.TxWitness, bool) {
+	if !bytes.Equal(pkScript, k.pkScript) {
+		return nil, false
+	}
+	// a schnorr signature with the default sighash or a DER encoded ecdsa signature with the sighash flag
+	signatureSize := 73
+	if k.addressType == P2TRAddress {
+		signatureSize = schnorr.SignatureSize
+	}
+	signatures := make(map[int][]byte, k.threshold)
+	for i := 0; i < k.threshold; i++ {
+		signatures[i] = make([]byte, signatureSize)
+	}
+	return k.finalizeWitness(signatures), true
+}
+
+// GetPublicKey returns the public key of the local cosigner, nil in reader mode
+func (k *multisigKeychain) GetPublicKey() *secp256k1.PublicKey {
+	return k.localPublicKey
+}
+
+// GetAddress returns the multisig address
+func (k *multisigKeychain) GetAddress() btcutil.Address {
+	return k.address
+}
+
+// GetPkScript returns the output script of the multisig address
+func (k *multisigKeychain) GetPkScript() []byte {
+	return k.pkScript
+}
+
+// GetPkScripts returns the output scripts spendable by the keychain, only the multisig script
+func (k *multisigKeychain) GetPkScripts() [][]byte {
+	return [][]byte{k.pkScript}
+}
+
+// multisigKeyIndex returns the position of a public key in the multisig keys, -1 if it is not a participant
+func multisigKeyIndex(publicKeys []*secp256k1.PublicKey, publicKey *secp256k1.PublicKey) int {
+	for i := range publicKeys {
+		if publicKeys[i].IsEqual(publicKey) {
+			return i
+		}
+	}
+	return -1
+}
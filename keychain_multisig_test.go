@@ -0,0 +1,138 @@
+package btcman
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newMultisigTestConfig(t *testing.T, threshold int) (*Config, []*btcec.PrivateKey) {
+	privateKeys := make([]*btcec.PrivateKey, 3)
+	publicKeys := make([]string, 3)
+	for i := range privateKeys {
+		privateKey, err := btcec.NewPrivateKey()
+		require.NoError(t, err)
+		privateKeys[i] = privateKey
+		publicKeys[i] = hex.EncodeToString(privateKey.PubKey().SerializeCompressed())
+	}
+	wif, err := btcutil.NewWIF(privateKeys[0], &chaincfg.RegressionNetParams, true)
+	require.NoError(t, err)
+
+	return &Config{
+		PrivateKey:         wif.String(),
+		MultisigPublicKeys: publicKeys,
+		MultisigThreshold:  threshold,
+	}, privateKeys
+}
+
+func TestMultisigKeychainSignTransaction(t *testing.T) {
+	for _, addressType := range []AddressType{P2WSHAddress, P2TRAddress} {
+		t.Run(string(addressType), func(t *testing.T) {
+			cfg, privateKeys := newMultisigTestConfig(t, 2)
+			k, err := NewMultisigKeychain(cfg, WriterMode, addressType, &chaincfg.RegressionNetParams, log.New("testing"),
+				NewLocalCosigner(privateKeys[2]))
+			require.NoError(t, err)
+
+			prevHash := chainhash.HashH([]byte(addressType))
+			prevValue := int64(100_000)
+			prevTx := &btcjson.TxRawResult{
+				Vout: []btcjson.Vout{{
+					Value:        btcutil.Amount(prevValue).ToBTC(),
+					ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(k.GetPkScript())},
+				}},
+			}
+			mockIndexer := new(mocks.Indexer)
+			mockIndexer.On("GetTransaction", mock.Anything, prevHash.String(), true).Return(prevTx, nil)
+
+			tx := wire.NewMsgTx(wire.TxVersion)
+			tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+			tx.AddTxOut(wire.NewTxOut(prevValue-1000, k.GetPkScript()))
+
+			require.NoError(t, k.SignTransaction(tx, NewPreviousOutPointFetcher(mockIndexer, log.New("testing"))))
+
+			prevOutFetcher := txscript.NewCannedPrevOutputFetcher(k.GetPkScript(), prevValue)
+			engine, err := txscript.NewEngine(k.GetPkScript(), tx, 0, txscript.StandardVerifyFlags, nil,
+				txscript.NewTxSigHashes(tx, prevOutFetcher), prevValue, prevOutFetcher)
+			require.NoError(t, err)
+			assert.NoError(t, engine.Execute())
+
+			// the inputs are sized by the template witness of the keychain
+			template, ok := k.(witnessTemplater).witnessTemplate(k.GetPkScript())
+			require.True(t, ok)
+			assert.Len(t, template, len(tx.TxIn[0].Witness))
+			assert.GreaterOrEqual(t, template.SerializeSize(), tx.TxIn[0].Witness.SerializeSize())
+			if addressType == P2TRAddress {
+				// a script path spend is larger than the key path spend of its script class
+				assert.Greater(t, witnessInputVSize(template), inputVSize(k.GetPkScript()))
+			}
+		})
+	}
+}
+
+// failingCosigner is a cosigner whose signing requests fail
+type failingCosigner struct {
+	publicKey *btcec.PublicKey
+}
+
+func (c *failingCosigner) GetPublicKey() *btcec.PublicKey {
+	return c.publicKey
+}
+
+func (c *failingCosigner) Sign(ctx context.Context, request *CosignRequest) ([][]byte, error) {
+	return nil, errors.New("cosigner unavailable")
+}
+
+func TestNewMultisigKeychainCosigners(t *testing.T) {
+	cfg, privateKeys := newMultisigTestConfig(t, 2)
+	_, err := NewMultisigKeychain(cfg, WriterMode, P2WSHAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	assert.Error(t, err)
+	// the local key counts once
+	_, err = NewMultisigKeychain(cfg, WriterMode, P2WSHAddress, &chaincfg.RegressionNetParams, log.New("testing"),
+		NewLocalCosigner(privateKeys[0]))
+	assert.Error(t, err)
+	_, err = NewMultisigKeychain(cfg, WriterMode, P2WSHAddress, &chaincfg.RegressionNetParams, log.New("testing"),
+		&failingCosigner{})
+	assert.Error(t, err)
+
+	// a reader doesn't sign
+	k, err := NewMultisigKeychain(cfg, ReaderMode, P2WSHAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	require.NoError(t, err)
+	assert.Nil(t, k.GetPublicKey())
+}
+
+func TestMultisigKeychainThresholdNotReached(t *testing.T) {
+	cfg, privateKeys := newMultisigTestConfig(t, 2)
+	k, err := NewMultisigKeychain(cfg, WriterMode, P2WSHAddress, &chaincfg.RegressionNetParams, log.New("testing"),
+		&failingCosigner{publicKey: privateKeys[1].PubKey()})
+	require.NoError(t, err)
+
+	prevHash := chainhash.HashH([]byte("threshold"))
+	prevTx := &btcjson.TxRawResult{
+		Vout: []btcjson.Vout{{
+			Value:        0.001,
+			ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(k.GetPkScript())},
+		}},
+	}
+	mockIndexer := new(mocks.Indexer)
+	mockIndexer.On("GetTransaction", mock.Anything, prevHash.String(), true).Return(prevTx, nil)
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, k.GetPkScript()))
+
+	assert.Error(t, k.SignTransaction(tx, NewPreviousOutPointFetcher(mockIndexer, log.New("testing"))))
+}
@@ -0,0 +1,522 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/grail-rollup/btcman/common"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// musigRoundTimeout is the maximum time to wait for the other participants in a signing round
+const musigRoundTimeout = 2 * time.Minute
+
+// musigProposalQueueSize is the number of proposals an in process participant can have pending
+const musigProposalQueueSize = 16
+
+// MusigSessionProposal is the transaction of a signing session sent by the proposer to the other participants.
+// Every participant computes the sighashes of the inputs from the transaction and the spent outputs before signing.
+type MusigSessionProposal struct {
+	SessionID string
+	Proposer  *btcec.PublicKey
+	Tx        *wire.MsgTx
+	PrevOuts  map[wire.OutPoint]*wire.TxOut
+}
+
+// MusigNonce is the public nonce of a participant in the first signing round
+type MusigNonce struct {
+	PublicKey *btcec.PublicKey
+	Nonce     [musig2.PubNonceSize]byte
+}
+
+// MusigPartialSignature is the partial signature of a participant in the second signing round
+type MusigPartialSignature struct {
+	PublicKey *btcec.PublicKey
+	Signature *musig2.PartialSignature
+}
+
+// MusigTransport carries the session proposals and exchanges the messages of the two MuSig2 signing rounds between
+// the participants. Every participant of a round sends its own message and receives the messages of all the others.
+type MusigTransport interface {
+	// ProposeSession sends the transaction of a signing session to the other participants
+	ProposeSession(ctx context.Context, proposal *MusigSessionProposal) error
+	// ReceiveProposal waits for a signing session proposed by another participant
+	ReceiveProposal(ctx context.Context, publicKey *btcec.PublicKey) (*MusigSessionProposal, error)
+	ExchangeNonces(ctx context.Context, sessionID string, nonce MusigNonce) ([]MusigNonce, error)
+	ExchangePartialSignatures(ctx context.Context, sessionID string, signature MusigPartialSignature) ([]MusigPartialSignature, error)
+}
+
+// MusigParticipant is implemented by the MuSig2 keychains, it signs the sessions proposed by the other participants
+type MusigParticipant interface {
+	// SignProposal checks that the proposal spends only outputs of the keychain and is approved by
+	// Config.MusigApproveProposal, then takes part in its signing rounds
+	SignProposal(ctx context.Context, proposal *MusigSessionProposal) error
+}
+
+// musig2Keychain is a keychain where the wallet output is a BIP86 taproot key aggregated from the participant keys,
+// spending requires a partial signature of every participant
+type musig2Keychain struct {
+	mode            BtcmanMode
+	privateKey      *btcec.PrivateKey
+	publicKeys      []*btcec.PublicKey
+	outputKey       *btcec.PublicKey
+	address         btcutil.Address
+	pkScript        []byte
+	transport       MusigTransport
+	approveProposal func(*MusigSessionProposal) error
+	roundTimeout    time.Duration
+	network         *chaincfg.Params
+	logger          log.Logger
+}
+
+// NewMusig2Keychain creates a MuSig2 keychain from the configured participant public keys. In writer mode
+// the configured private key signs for the local participant and the transport is required for the signing rounds.
+func NewMusig2Keychain(cfg *Config, mode BtcmanMode, network *chaincfg.Params, parentLogger log.Logger, transport MusigTransport) (Keychainer, error) {
+	keychainLogger := parentLogger.New("module", common.KEYCHAIN)
+
+	if len(cfg.MusigPublicKeys) < 2 {
+		return nil, fmt.Errorf("at least two musig public keys are required for a musig2 keychain")
+	}
+
+	publicKeys := make([]*btcec.PublicKey, len(cfg.MusigPublicKeys))
+	for i, publicKeyHex := range cfg.MusigPublicKeys {
+		publicKeyBytes, err := hex.DecodeString(publicKeyHex)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding musig public key %d", i)
+		}
+		publicKeys[i], err = btcec.ParsePubKey(publicKeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding musig public key %d", i)
+		}
+	}
+	sort.Slice(publicKeys, func(i, j int) bool {
+		return bytes.Compare(publicKeys[i].SerializeCompressed(), publicKeys[j].SerializeCompressed()) < 0
+	})
+
+	var privateKey *btcec.PrivateKey
+	if mode == WriterMode {
+		if cfg.PrivateKey == "" {
+			return nil, fmt.Errorf("private key is required for btcman in writer mode")
+		}
+		if transport == nil {
+			return nil, fmt.Errorf("musig transport is required for btcman in writer mode")
+		}
+		wif, err := btcutil.DecodeWIF(cfg.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding wif private key")
+		}
+		privateKey = wif.PrivKey
+		if multisigKeyIndex(publicKeys, privateKey.PubKey()) == -1 {
+			return nil, fmt.Errorf("private key is not a musig participant")
+		}
+	}
+
+	aggregateKey, _, _, err := musig2.AggregateKeys(publicKeys, true, musig2.WithBIP86KeyTweak())
+	if err != nil {
+		return nil, err
+	}
+	address, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(aggregateKey.FinalKey), network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate P2TR address: %v", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &musig2Keychain{
+		mode:            mode,
+		privateKey:      privateKey,
+		publicKeys:      publicKeys,
+		outputKey:       aggregateKey.FinalKey,
+		address:         address,
+		pkScript:        pkScript,
+		transport:       transport,
+		approveProposal: cfg.MusigApproveProposal,
+		roundTimeout:    musigRoundTimeout,
+		network:         network,
+		logger:          keychainLogger,
+	}, nil
+}
+
+// SignTransaction proposes the transaction to the other participants and runs a MuSig2 signing session
+// for every input, prevOutFetcher provides the outputs spent by the inputs
+func (k *musig2Keychain) SignTransaction(rawTransaction *wire.MsgTx, prevOutFetcher txscript.PrevOutputFetcher) error {
+	if k.mode == ReaderMode {
+		return fmt.Errorf("btcman in reader mode does not support signing transactions")
+	}
+
+	prevOutList, err := fetchPrevOuts(rawTransaction, prevOutFetcher)
+	if err != nil {
+		return err
+	}
+	if err := k.checkPrevOuts(prevOutList); err != nil {
+		return err
+	}
+	proposal := &MusigSessionProposal{
+		SessionID: rawTransaction.TxHash().String(),
+		Proposer:  k.privateKey.PubKey(),
+		Tx:        rawTransaction,
+		PrevOuts:  make(map[wire.OutPoint]*wire.TxOut, len(rawTransaction.TxIn)),
+	}
+	for idx, txInput := range rawTransaction.TxIn {
+		proposal.PrevOuts[txInput.PreviousOutPoint] = prevOutList[idx]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), k.roundTimeout)
+	defer cancel()
+	if err := k.transport.ProposeSession(ctx, proposal); err != nil {
+		return fmt.Errorf("error proposing musig2 session: %v", err)
+	}
+	witnesses, err := k.signProposal(context.Background(), proposal)
+	if err != nil {
+		return err
+	}
+
+	for idx, txInput := range rawTransaction.TxIn {
+		txInput.Witness = witnesses[idx]
+	}
+	k.logger.Info("MuSig2 transaction signed successfully")
+	return nil
+}
+
+// SignProposal takes part in the signing rounds of a session proposed by another participant,
+// a proposal rejected by the approval hook isn't signed
+func (k *musig2Keychain) SignProposal(ctx context.Context, proposal *MusigSessionProposal) error {
+	if k.mode == ReaderMode {
+		return fmt.Errorf("btcman in reader mode does not support signing transactions")
+	}
+	// the nonce round isn't joined for a rejected proposal, so the session can't complete
+	if k.approveProposal != nil {
+		if err := k.approveProposal(proposal); err != nil {
+			k.logger.Warn("MuSig2 session rejected", "sessionID", proposal.SessionID, "err", err)
+			return fmt.Errorf("musig2 session %s rejected: %v", proposal.SessionID, err)
+		}
+	}
+	if _, err := k.signProposal(ctx, proposal); err != nil {
+		return err
+	}
+	k.logger.Info("MuSig2 session signed successfully", "sessionID", proposal.SessionID)
+	return nil
+}
+
+// signProposal runs the signing session of every input of the proposal and returns the input witnesses
+func (k *musig2Keychain) signProposal(ctx context.Context, proposal *MusigSessionProposal) ([]wire.TxWitness, error) {
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(proposal.PrevOuts)
+	prevOuts, err := fetchPrevOuts(proposal.Tx, prevOutFetcher)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.checkPrevOuts(prevOuts); err != nil {
+		return nil, err
+	}
+
+	sigHashes := txscript.NewTxSigHashes(proposal.Tx, prevOutFetcher)
+	witnesses := make([]wire.TxWitness, len(proposal.Tx.TxIn))
+	for idx := range proposal.Tx.TxIn {
+		sigHash, err := txscript.CalcTaprootSignatureHash(sigHashes, txscript.SigHashDefault, proposal.Tx, idx, prevOutFetcher)
+		if err != nil {
+			return nil, err
+		}
+		var msg [32]byte
+		copy(msg[:], sigHash)
+
+		sessionID := fmt.Sprintf("%s:%d", proposal.SessionID, idx)
+		signature, err := k.runSession(ctx, sessionID, msg)
+		if err != nil {
+			return nil, fmt.Errorf("musig2 session of input %d failed: %v", idx, err)
+		}
+		// the witness is written only if the signature is valid for the output key
+		if !signature.Verify(msg[:], k.outputKey) {
+			return nil, fmt.Errorf("musig2 signature of input %d is invalid", idx)
+		}
+		witnesses[idx] = wire.TxWitness{signature.Serialize()}
+	}
+	return witnesses, nil
+}
+
+// checkPrevOuts returns an error if an input spends an output of another script than the aggregated key one
+func (k *musig2Keychain) checkPrevOuts(prevOuts []*wire.TxOut) error {
+	for idx, prevOut := range prevOuts {
+		if !bytes.Equal(prevOut.PkScript, k.pkScript) {
+			return fmt.Errorf("input %d spends an output not controlled by the musig2 keychain", idx)
+		}
+	}
+	return nil
+}
+
+// runSession exchanges the nonces and the partial signatures of a message and returns the aggregated signature,
+// the partial signature of every participant is verified against its nonce and public key
+func (k *musig2Keychain) runSession(parentCtx context.Context, sessionID string, msg [32]byte) (*schnorr.Signature, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, k.roundTimeout)
+	defer cancel()
+
+	musigCtx, err := musig2.NewContext(k.privateKey, true, musig2.WithKnownSigners(k.publicKeys), musig2.WithBip86TweakCtx())
+	if err != nil {
+		return nil, err
+	}
+	session, err := musigCtx.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	publicKey := k.privateKey.PubKey()
+
+	// first round, public nonces
+	nonces, err := k.transport.ExchangeNonces(ctx, sessionID, MusigNonce{PublicKey: publicKey, Nonce: session.PublicNonce()})
+	if err != nil {
+		return nil, err
+	}
+	participantNonces := map[int][musig2.PubNonceSize]byte{
+		multisigKeyIndex(k.publicKeys, publicKey): session.PublicNonce(),
+	}
+	for _, nonce := range nonces {
+		keyIndex, err := k.participantIndex(nonce.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := participantNonces[keyIndex]; ok {
+			return nil, fmt.Errorf("participant %d sent more than one nonce", keyIndex)
+		}
+		participantNonces[keyIndex] = nonce.Nonce
+		if _, err := session.RegisterPubNonce(nonce.Nonce); err != nil {
+			return nil, err
+		}
+	}
+	if len(participantNonces) != len(k.publicKeys) {
+		return nil, fmt.Errorf("received %d of %d nonces", len(participantNonces), len(k.publicKeys))
+	}
+	allNonces := make([][musig2.PubNonceSize]byte, 0, len(participantNonces))
+	for _, nonce := range participantNonces {
+		allNonces = append(allNonces, nonce)
+	}
+	combinedNonce, err := musig2.AggregateNonces(allNonces)
+	if err != nil {
+		return nil, err
+	}
+
+	// second round, partial signatures
+	partialSignature, err := session.Sign(msg)
+	if err != nil {
+		return nil, err
+	}
+	partialSignatures, err := k.transport.ExchangePartialSignatures(ctx, sessionID, MusigPartialSignature{PublicKey: publicKey, Signature: partialSignature})
+	if err != nil {
+		return nil, err
+	}
+	signed := make(map[int]bool, len(partialSignatures))
+	for _, signature := range partialSignatures {
+		keyIndex, err := k.participantIndex(signature.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if signed[keyIndex] {
+			return nil, fmt.Errorf("participant %d sent more than one partial signature", keyIndex)
+		}
+		signed[keyIndex] = true
+		if signature.Signature == nil || !signature.Signature.Verify(participantNonces[keyIndex], combinedNonce, k.publicKeys,
+			signature.PublicKey, msg, musig2.WithSortedKeys(), musig2.WithBip86SignTweak()) {
+			return nil, fmt.Errorf("invalid partial signature of participant %x", signature.PublicKey.SerializeCompressed())
+		}
+		if _, err := session.CombineSig(signature.Signature); err != nil {
+			return nil, err
+		}
+	}
+
+	finalSignature := session.FinalSig()
+	if finalSignature == nil {
+		return nil, fmt.Errorf("missing partial signatures")
+	}
+	return finalSignature, nil
+}
+
+// participantIndex returns the position of the key of a remote participant in the sorted keys
+func (k *musig2Keychain) participantIndex(publicKey *btcec.PublicKey) (int, error) {
+	if publicKey == nil {
+		return -1, fmt.Errorf("message without participant key")
+	}
+	keyIndex := multisigKeyIndex(k.publicKeys, publicKey)
+	if keyIndex == -1 {
+		return -1, fmt.Errorf("%x is not a musig participant", publicKey.SerializeCompressed())
+	}
+	if publicKey.IsEqual(k.privateKey.PubKey()) {
+		return -1, fmt.Errorf("message with the local participant key")
+	}
+	return keyIndex, nil
+}
+
+// GetPublicKey returns the public key of the local participant, nil in reader mode
+func (k *musig2Keychain) GetPublicKey() *secp256k1.PublicKey {
+	if k.privateKey == nil {
+		return nil
+	}
+	return k.privateKey.PubKey()
+}
+
+// witnessTemplate returns a witness of the size of a signed input spending pkScript, a key path signature placeholder
+func (k *musig2Keychain) witnessTemplate(pkScript []byte) (wire.TxWitness, bool) {
+	if !bytes.Equal(pkScript, k.pkScript) {
+		return nil, false
+	}
+	return wire.TxWitness{make([]byte, schnorr.SignatureSize)}, true
+}
+
+// GetAddress returns the taproot address of the aggregated key
+func (k *musig2Keychain) GetAddress() btcutil.Address {
+	return k.address
+}
+
+// GetPkScript returns the output script of the aggregated key address
+func (k *musig2Keychain) GetPkScript() []byte {
+	return k.pkScript
+}
+
+// GetPkScripts returns the output scripts spendable by the keychain, only the aggregated key script
+func (k *musig2Keychain) GetPkScripts() [][]byte {
+	return [][]byte{k.pkScript}
+}
+
+// inProcessMusigTransport is a MusigTransport for participants running in the same process
+type inProcessMusigTransport struct {
+	numParticipants int
+	// proposals holds the sessions pending for every participant, by the hex of its public key
+	proposals map[string]chan *MusigSessionProposal
+	lock      sync.Mutex
+	rounds    map[string]*musigRound
+}
+
+// musigRound holds the messages of a single round of a session
+type musigRound struct {
+	messages map[string]interface{}
+	reads    int
+	done     chan struct{}
+}
+
+// NewInProcessMusigTransport returns a transport shared by all the participants of the same process
+func NewInProcessMusigTransport(publicKeys ...*btcec.PublicKey) MusigTransport {
+	proposals := make(map[string]chan *MusigSessionProposal, len(publicKeys))
+	for _, publicKey := range publicKeys {
+		proposals[hex.EncodeToString(publicKey.SerializeCompressed())] = make(chan *MusigSessionProposal, musigProposalQueueSize)
+	}
+	return &inProcessMusigTransport{
+		numParticipants: len(publicKeys),
+		proposals:       proposals,
+		rounds:          make(map[string]*musigRound),
+	}
+}
+
+// ProposeSession queues the proposal for every participant but the proposer
+func (t *inProcessMusigTransport) ProposeSession(ctx context.Context, proposal *MusigSessionProposal) error {
+	proposer := hex.EncodeToString(proposal.Proposer.SerializeCompressed())
+	if _, ok := t.proposals[proposer]; !ok {
+		return fmt.Errorf("proposer %s is not a participant", proposer)
+	}
+	for participant, proposals := range t.proposals {
+		if participant == proposer {
+			continue
+		}
+		select {
+		case proposals <- proposal:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// ReceiveProposal waits for the next proposal queued for the participant
+func (t *inProcessMusigTransport) ReceiveProposal(ctx context.Context, publicKey *btcec.PublicKey) (*MusigSessionProposal, error) {
+	proposals, ok := t.proposals[hex.EncodeToString(publicKey.SerializeCompressed())]
+	if !ok {
+		return nil, fmt.Errorf("%x is not a participant", publicKey.SerializeCompressed())
+	}
+	select {
+	case proposal := <-proposals:
+		return proposal, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// ExchangeNonces publishes the nonce and waits for the nonces of all the other participants
+func (t *inProcessMusigTransport) ExchangeNonces(ctx context.Context, sessionID string, nonce MusigNonce) ([]MusigNonce, error) {
+	messages, err := t.exchange(ctx, sessionID+"/nonces", nonce.PublicKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	nonces := make([]MusigNonce, len(messages))
+	for i := range messages {
+		nonces[i] = messages[i].(MusigNonce)
+	}
+	return nonces, nil
+}
+
+// ExchangePartialSignatures publishes the partial signature and waits for the signatures of all the other participants
+func (t *inProcessMusigTransport) ExchangePartialSignatures(ctx context.Context, sessionID string, signature MusigPartialSignature) ([]MusigPartialSignature, error) {
+	messages, err := t.exchange(ctx, sessionID+"/signatures", signature.PublicKey, signature)
+	if err != nil {
+		return nil, err
+	}
+	signatures := make([]MusigPartialSignature, len(messages))
+	for i := range messages {
+		signatures[i] = messages[i].(MusigPartialSignature)
+	}
+	return signatures, nil
+}
+
+// exchange stores the message of the participant and returns the messages of the others once everyone has sent theirs
+func (t *inProcessMusigTransport) exchange(ctx context.Context, roundID string, publicKey *btcec.PublicKey, message interface{}) ([]interface{}, error) {
+	sender := hex.EncodeToString(publicKey.SerializeCompressed())
+
+	t.lock.Lock()
+	round, ok := t.rounds[roundID]
+	if !ok {
+		round = &musigRound{
+			messages: make(map[string]interface{}, t.numParticipants),
+			done:     make(chan struct{}),
+		}
+		t.rounds[roundID] = round
+	}
+	if _, ok := round.messages[sender]; ok {
+		t.lock.Unlock()
+		return nil, fmt.Errorf("participant already sent a message in round %s", roundID)
+	}
+	round.messages[sender] = message
+	if len(round.messages) == t.numParticipants {
+		close(round.done)
+	}
+	t.lock.Unlock()
+
+	select {
+	case <-round.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	messages := make([]interface{}, 0, t.numParticipants-1)
+	for participant, participantMessage := range round.messages {
+		if participant != sender {
+			messages = append(messages, participantMessage)
+		}
+	}
+	// the round is removed once every participant has received the messages
+	round.reads++
+	if round.reads == t.numParticipants {
+		delete(t.rounds, roundID)
+	}
+	return messages, nil
+}
@@ -0,0 +1,180 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newMusig2TestKeychains returns the keychains of the participants of a musig2 wallet sharing a transport
+func newMusig2TestKeychains(t *testing.T, numParticipants int, newTransport func(MusigTransport) MusigTransport) ([]Keychainer, MusigTransport, []*btcec.PrivateKey) {
+	privateKeys := make([]*btcec.PrivateKey, numParticipants)
+	publicKeys := make([]string, numParticipants)
+	participantKeys := make([]*btcec.PublicKey, numParticipants)
+	for i := range privateKeys {
+		privateKey, err := btcec.NewPrivateKey()
+		require.NoError(t, err)
+		privateKeys[i] = privateKey
+		participantKeys[i] = privateKey.PubKey()
+		publicKeys[i] = hex.EncodeToString(privateKey.PubKey().SerializeCompressed())
+	}
+
+	transport := newTransport(NewInProcessMusigTransport(participantKeys...))
+	keychains := make([]Keychainer, numParticipants)
+	for i := range keychains {
+		wif, err := btcutil.NewWIF(privateKeys[i], &chaincfg.RegressionNetParams, true)
+		require.NoError(t, err)
+		cfg := &Config{PrivateKey: wif.String(), MusigPublicKeys: publicKeys}
+		keychains[i], err = NewMusig2Keychain(cfg, WriterMode, &chaincfg.RegressionNetParams, log.New("testing"), transport)
+		require.NoError(t, err)
+	}
+	return keychains, transport, privateKeys
+}
+
+// newMusig2TestTx returns a transaction spending two outputs of the script and the indexer serving them
+func newMusig2TestTx(pkScript []byte, prevValue int64) (*wire.MsgTx, *mocks.Indexer) {
+	mockIndexer := new(mocks.Indexer)
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for i := 0; i < 2; i++ {
+		prevHash := chainhash.HashH([]byte{byte(i)})
+		prevTx := &btcjson.TxRawResult{
+			Vout: []btcjson.Vout{{
+				Value:        btcutil.Amount(prevValue).ToBTC(),
+				ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(pkScript)},
+			}},
+		}
+		mockIndexer.On("GetTransaction", mock.Anything, prevHash.String(), true).Return(prevTx, nil)
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	}
+	tx.AddTxOut(wire.NewTxOut(2*prevValue-1000, pkScript))
+	return tx, mockIndexer
+}
+
+// signMusig2TestTx signs the transaction with the first keychain while the others sign its proposal,
+// the participants wait at most the timeout, returns the errors of every participant
+func signMusig2TestTx(keychains []Keychainer, transport MusigTransport, privateKeys []*btcec.PrivateKey, tx *wire.MsgTx, mockIndexer *mocks.Indexer, timeout time.Duration) []error {
+	errs := make([]error, len(keychains))
+	var wg sync.WaitGroup
+	for i := range keychains {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 0 {
+				errs[i] = keychains[i].SignTransaction(tx, NewPreviousOutPointFetcher(mockIndexer, log.New("testing")))
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			proposal, err := transport.ReceiveProposal(ctx, privateKeys[i].PubKey())
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = keychains[i].(MusigParticipant).SignProposal(ctx, proposal)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestMusig2KeychainSignTransaction(t *testing.T) {
+	keychains, transport, privateKeys := newMusig2TestKeychains(t, 3, func(transport MusigTransport) MusigTransport { return transport })
+	pkScript := keychains[0].GetPkScript()
+	for _, k := range keychains {
+		assert.Equal(t, pkScript, k.GetPkScript())
+	}
+	assert.Equal(t, txscript.WitnessV1TaprootTy, txscript.GetScriptClass(pkScript))
+
+	prevValue := int64(100_000)
+	tx, mockIndexer := newMusig2TestTx(pkScript, prevValue)
+	for _, err := range signMusig2TestTx(keychains, transport, privateKeys, tx, mockIndexer, 5*time.Second) {
+		require.NoError(t, err)
+	}
+
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for _, txIn := range tx.TxIn {
+		prevOutFetcher.AddPrevOut(txIn.PreviousOutPoint, wire.NewTxOut(prevValue, pkScript))
+	}
+	for idx := range tx.TxIn {
+		engine, err := txscript.NewEngine(pkScript, tx, idx, txscript.StandardVerifyFlags, nil,
+			txscript.NewTxSigHashes(tx, prevOutFetcher), prevValue, prevOutFetcher)
+		require.NoError(t, err)
+		assert.NoError(t, engine.Execute())
+	}
+}
+
+// tamperingMusigTransport corrupts the partial signatures of a participant
+type tamperingMusigTransport struct {
+	MusigTransport
+	publicKey *btcec.PublicKey
+}
+
+func (t *tamperingMusigTransport) ExchangePartialSignatures(ctx context.Context, sessionID string, signature MusigPartialSignature) ([]MusigPartialSignature, error) {
+	signatures, err := t.MusigTransport.ExchangePartialSignatures(ctx, sessionID, signature)
+	for i := range signatures {
+		if signatures[i].PublicKey.IsEqual(t.publicKey) {
+			s := *signatures[i].Signature.S
+			tampered := musig2.NewPartialSignature(s.Add(new(btcec.ModNScalar).SetInt(1)), signatures[i].Signature.R)
+			signatures[i].Signature = &tampered
+		}
+	}
+	return signatures, err
+}
+
+func TestMusig2KeychainInvalidPartialSignature(t *testing.T) {
+	tampering := &tamperingMusigTransport{}
+	keychains, transport, privateKeys := newMusig2TestKeychains(t, 3, func(transport MusigTransport) MusigTransport {
+		tampering.MusigTransport = transport
+		return tampering
+	})
+	tampering.publicKey = privateKeys[2].PubKey()
+
+	tx, mockIndexer := newMusig2TestTx(keychains[0].GetPkScript(), 100_000)
+	// the participant whose signatures are tampered waits for the signing round of the next input until the timeout
+	errs := signMusig2TestTx(keychains, transport, privateKeys, tx, mockIndexer, 100*time.Millisecond)
+	require.Error(t, errs[0])
+	assert.Contains(t, errs[0].Error(), "invalid partial signature")
+	assert.Nil(t, tx.TxIn[0].Witness)
+}
+
+func TestMusig2KeychainRejectedProposal(t *testing.T) {
+	keychains, transport, privateKeys := newMusig2TestKeychains(t, 3, func(transport MusigTransport) MusigTransport { return transport })
+	pkScript := keychains[0].GetPkScript()
+	// the last participant only signs the transactions paying back to the wallet
+	keychains[2].(*musig2Keychain).approveProposal = func(proposal *MusigSessionProposal) error {
+		for _, txOut := range proposal.Tx.TxOut {
+			if !bytes.Equal(txOut.PkScript, pkScript) {
+				return errors.New("unexpected output")
+			}
+		}
+		return nil
+	}
+	keychains[0].(*musig2Keychain).roundTimeout = 100 * time.Millisecond
+
+	tx, mockIndexer := newMusig2TestTx(pkScript, 100_000)
+	tx.TxOut[0].PkScript = []byte{txscript.OP_TRUE}
+	errs := signMusig2TestTx(keychains, transport, privateKeys, tx, mockIndexer, 100*time.Millisecond)
+	require.Error(t, errs[2])
+	assert.Contains(t, errs[2].Error(), "rejected")
+	// the session can't complete without the nonce of the rejecting participant
+	assert.Error(t, errs[0])
+	assert.Nil(t, tx.TxIn[0].Witness)
+}
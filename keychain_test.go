@@ -0,0 +1,152 @@
+package btcman
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testPrivateKey = "cSaejkcWwU25jMweWEewRSsrVQq2FGTij1xjXv4x1XvxVRF1ZCr3"
+
+func TestKeychainAddress(t *testing.T) {
+	tests := []struct {
+		name          string
+		addressType   AddressType
+		expectedClass txscript.ScriptClass
+	}{
+		{
+			name:          "P2WPKH address",
+			addressType:   P2WPKHAddress,
+			expectedClass: txscript.WitnessV0PubKeyHashTy,
+		},
+		{
+			name:          "P2TR address",
+			addressType:   P2TRAddress,
+			expectedClass: txscript.WitnessV1TaprootTy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{PrivateKey: testPrivateKey}
+			k, err := NewKeychain(cfg, WriterMode, tt.addressType, &chaincfg.RegressionNetParams, log.New("testing"))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expectedClass, txscript.GetScriptClass(k.GetPkScript()))
+
+			pkScript, err := txscript.PayToAddrScript(k.GetAddress())
+			require.NoError(t, err)
+			assert.Equal(t, k.GetPkScript(), pkScript)
+		})
+	}
+}
+
+func TestKeychainSignTransaction(t *testing.T) {
+	cfg := &Config{PrivateKey: testPrivateKey}
+	k, err := NewKeychain(cfg, WriterMode, P2WPKHAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	require.NoError(t, err)
+	assert.Len(t, k.GetPkScripts(), len(spendableAddressTypes))
+
+	for _, addressType := range spendableAddressTypes {
+		t.Run(string(addressType), func(t *testing.T) {
+			prevPkScript := k.(*keychain).pkScripts[addressType]
+			prevHash := chainhash.HashH([]byte(addressType))
+			prevValue := int64(100_000)
+			prevTx := &btcjson.TxRawResult{
+				Vout: []btcjson.Vout{{
+					Value:        btcutil.Amount(prevValue).ToBTC(),
+					ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(prevPkScript)},
+				}},
+			}
+			mockIndexer := new(mocks.Indexer)
+			mockIndexer.On("GetTransaction", mock.Anything, prevHash.String(), true).Return(prevTx, nil)
+
+			tx := wire.NewMsgTx(wire.TxVersion)
+			tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+			tx.AddTxOut(wire.NewTxOut(prevValue-1000, k.GetPkScript()))
+
+			require.NoError(t, k.SignTransaction(tx, NewPreviousOutPointFetcher(mockIndexer, log.New("testing"))))
+
+			prevOutFetcher := txscript.NewCannedPrevOutputFetcher(prevPkScript, prevValue)
+			engine, err := txscript.NewEngine(prevPkScript, tx, 0, txscript.StandardVerifyFlags, nil,
+				txscript.NewTxSigHashes(tx, prevOutFetcher), prevValue, prevOutFetcher)
+			require.NoError(t, err)
+			assert.NoError(t, engine.Execute())
+		})
+	}
+}
+
+func TestKeychainSignForeignScript(t *testing.T) {
+	cfg := &Config{PrivateKey: testPrivateKey}
+	k, err := NewKeychain(cfg, WriterMode, P2WPKHAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	require.NoError(t, err)
+
+	foreignPkScript := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, make([]byte, 20)...)
+	prevHash := chainhash.HashH([]byte("foreign"))
+	prevTx := &btcjson.TxRawResult{
+		Vout: []btcjson.Vout{{
+			Value:        0.001,
+			ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(foreignPkScript)},
+		}},
+	}
+	mockIndexer := new(mocks.Indexer)
+	mockIndexer.On("GetTransaction", mock.Anything, prevHash.String(), true).Return(prevTx, nil)
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, k.GetPkScript()))
+
+	assert.Error(t, k.SignTransaction(tx, NewPreviousOutPointFetcher(mockIndexer, log.New("testing"))))
+}
+
+func TestKeychainSignTransactionFetchesPrevTxOnce(t *testing.T) {
+	cfg := &Config{PrivateKey: testPrivateKey}
+	k, err := NewKeychain(cfg, WriterMode, P2TRAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	require.NoError(t, err)
+
+	prevHash := chainhash.HashH([]byte("consolidation"))
+	prevTx := &btcjson.TxRawResult{}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for i := 0; i < 5; i++ {
+		prevTx.Vout = append(prevTx.Vout, btcjson.Vout{
+			Value:        0.001,
+			ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(k.GetPkScript())},
+		})
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, uint32(i)), nil, nil))
+	}
+	tx.AddTxOut(wire.NewTxOut(400_000, k.GetPkScript()))
+
+	mockIndexer := new(mocks.Indexer)
+	mockIndexer.On("GetTransaction", mock.Anything, prevHash.String(), true).Return(prevTx, nil)
+
+	require.NoError(t, k.SignTransaction(tx, NewPreviousOutPointFetcher(mockIndexer, log.New("testing"))))
+	mockIndexer.AssertNumberOfCalls(t, "GetTransaction", 1)
+}
+
+func TestKeychainSignTransactionMissingPrevTx(t *testing.T) {
+	cfg := &Config{PrivateKey: testPrivateKey}
+	k, err := NewKeychain(cfg, WriterMode, P2WPKHAddress, &chaincfg.RegressionNetParams, log.New("testing"))
+	require.NoError(t, err)
+
+	prevHash := chainhash.HashH([]byte("missing"))
+	mockIndexer := new(mocks.Indexer)
+	mockIndexer.On("GetTransaction", mock.Anything, prevHash.String(), true).Return((*btcjson.TxRawResult)(nil), errors.New("not found"))
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, k.GetPkScript()))
+
+	assert.Error(t, k.SignTransaction(tx, NewPreviousOutPointFetcher(mockIndexer, log.New("testing"))))
+}
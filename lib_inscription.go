@@ -19,9 +19,15 @@ import (
 )
 
 type InscriptionData struct {
-	ContentType string
-	Body        []byte
-	Destination string
+	ContentType     string
+	ContentEncoding string
+	Metadata        []byte // CBOR encoded
+	Metaprotocol    string
+	Pointer         *uint64
+	Parent          *InscriptionID // the parent inscription must be spent by the reveal tx in order to be recognized
+	Delegate        *InscriptionID
+	Body            []byte
+	Destination     string
 }
 
 type InscriptionRequest struct {
@@ -35,6 +41,7 @@ type InscriptionRequest struct {
 	SingleRevealTxOnly bool // Currently, the official Ordinal parser can only parse a single NFT per transaction.
 	// When the official Ordinal parser supports parsing multiple NFTs in the future, we can consider using a single reveal transaction.
 	RevealOutValue int64
+	RevealTag      []byte // data of an OP_RETURN output added to every reveal tx, at most MaxStandardOpReturnSize bytes
 }
 
 type inscriptionTxCtxData struct {
@@ -67,6 +74,8 @@ const (
 	defaultRevealOutValue = int64(1000) // 1000 sat
 
 	MaxStandardTxWeight = blockchain.MaxBlockWeight / 10
+	// MaxStandardOpReturnSize is the maximum data size of a standard OP_RETURN output
+	MaxStandardOpReturnSize = txscript.MaxDataCarrierSize
 )
 
 func NewInscriptionTool(net *chaincfg.Params, request *InscriptionRequest, indexerClient indexer.Indexerer, keychain Keychainer) (*InscriptionTool, error) {
@@ -84,21 +93,7 @@ func NewInscriptionTool(net *chaincfg.Params, request *InscriptionRequest, index
 }
 
 func (tool *InscriptionTool) _initTool(net *chaincfg.Params, request *InscriptionRequest) error {
-	revealOutValue := defaultRevealOutValue
-	if request.RevealOutValue > 0 {
-		revealOutValue = request.RevealOutValue
-	}
-	tool.txCtxDataList = make([]*inscriptionTxCtxData, len(request.DataList))
-	destinations := make([]string, len(request.DataList))
-	for i := 0; i < len(request.DataList); i++ {
-		txCtxData, err := createInscriptionTxCtxData(net, request.DataList[i])
-		if err != nil {
-			return err
-		}
-		tool.txCtxDataList[i] = txCtxData
-		destinations[i] = request.DataList[i].Destination
-	}
-	totalRevealPrevOutput, err := tool.buildEmptyRevealTx(request.SingleRevealTxOnly, destinations, revealOutValue, request.FeeRate)
+	totalRevealPrevOutput, err := tool.buildRevealTxs(net, request)
 	if err != nil {
 		return err
 	}
@@ -117,6 +112,47 @@ func (tool *InscriptionTool) _initTool(net *chaincfg.Params, request *Inscriptio
 	return err
 }
 
+// buildRevealTxs creates the inscription scripts and the unsigned reveal transactions,
+// it returns the total value the commit transaction has to send to the reveal transactions
+func (tool *InscriptionTool) buildRevealTxs(net *chaincfg.Params, request *InscriptionRequest) (int64, error) {
+	revealOutValue := defaultRevealOutValue
+	if request.RevealOutValue > 0 {
+		revealOutValue = request.RevealOutValue
+	}
+	tool.txCtxDataList = make([]*inscriptionTxCtxData, len(request.DataList))
+	destinations := make([]string, len(request.DataList))
+	for i := 0; i < len(request.DataList); i++ {
+		txCtxData, err := createInscriptionTxCtxData(net, request.DataList[i])
+		if err != nil {
+			return 0, err
+		}
+		tool.txCtxDataList[i] = txCtxData
+		destinations[i] = request.DataList[i].Destination
+	}
+	var revealTagScript []byte
+	if len(request.RevealTag) > 0 {
+		var err error
+		revealTagScript, err = txscript.NullDataScript(request.RevealTag)
+		if err != nil {
+			return 0, errors.Wrap(err, "invalid reveal tag")
+		}
+	}
+	return tool.buildEmptyRevealTx(request.SingleRevealTxOnly, destinations, revealOutValue, revealTagScript, request.FeeRate)
+}
+
+// estimateCommitTxOutputs returns the outputs of the commit transaction of a request without funding it
+func estimateCommitTxOutputs(net *chaincfg.Params, request *InscriptionRequest) ([]*wire.TxOut, error) {
+	tool := &InscriptionTool{net: net}
+	if _, err := tool.buildRevealTxs(net, request); err != nil {
+		return nil, err
+	}
+	outputs := make([]*wire.TxOut, len(tool.txCtxDataList))
+	for i := range tool.txCtxDataList {
+		outputs[i] = tool.txCtxDataList[i].revealTxPrevOutput
+	}
+	return outputs, nil
+}
+
 func createInscriptionTxCtxData(net *chaincfg.Params, data InscriptionData) (*inscriptionTxCtxData, error) {
 	privateKey, err := btcec.NewPrivateKey()
 	if err != nil {
@@ -127,15 +163,12 @@ func createInscriptionTxCtxData(net *chaincfg.Params, data InscriptionData) (*in
 		AddOp(txscript.OP_CHECKSIG).
 		AddOp(txscript.OP_FALSE).
 		AddOp(txscript.OP_IF).
-		AddData([]byte("ord")).
-		AddOp(txscript.OP_DATA_1).
-		AddOp(txscript.OP_DATA_1).
-		AddData([]byte(data.ContentType)).
-		AddOp(txscript.OP_0)
-	maxChunkSize := 520
+		AddData(envelopeProtocolID)
+	addInscriptionTags(inscriptionBuilder, data)
+	inscriptionBuilder.AddOp(txscript.OP_0)
 	bodySize := len(data.Body)
-	for i := 0; i < bodySize; i += maxChunkSize {
-		end := i + maxChunkSize
+	for i := 0; i < bodySize; i += maxPushSize {
+		end := i + maxPushSize
 		if end > bodySize {
 			end = bodySize
 		}
@@ -185,7 +218,40 @@ func createInscriptionTxCtxData(net *chaincfg.Params, data InscriptionData) (*in
 	}, nil
 }
 
-func (tool *InscriptionTool) buildEmptyRevealTx(singleRevealTxOnly bool, destination []string, revealOutValue, feeRate int64) (int64, error) {
+// addInscriptionTags adds the envelope tags of the inscription data in ascending tag order
+func addInscriptionTags(builder *txscript.ScriptBuilder, data InscriptionData) {
+	addTag := func(tag byte, value []byte) {
+		// tags and values are pushed with data opcodes, ordinals flag the minimal OP_n encoding of single bytes
+		builder.AddOp(txscript.OP_DATA_1).AddOp(tag).AddFullData(value)
+	}
+	if data.ContentType != "" {
+		addTag(TagContentType, []byte(data.ContentType))
+	}
+	if data.Pointer != nil {
+		addTag(TagPointer, encodePointer(*data.Pointer))
+	}
+	if data.Parent != nil {
+		addTag(TagParent, data.Parent.encode())
+	}
+	for i := 0; i < len(data.Metadata); i += maxPushSize {
+		end := i + maxPushSize
+		if end > len(data.Metadata) {
+			end = len(data.Metadata)
+		}
+		addTag(TagMetadata, data.Metadata[i:end])
+	}
+	if data.Metaprotocol != "" {
+		addTag(TagMetaprotocol, []byte(data.Metaprotocol))
+	}
+	if data.ContentEncoding != "" {
+		addTag(TagContentEncoding, []byte(data.ContentEncoding))
+	}
+	if data.Delegate != nil {
+		addTag(TagDelegate, data.Delegate.encode())
+	}
+}
+
+func (tool *InscriptionTool) buildEmptyRevealTx(singleRevealTxOnly bool, destination []string, revealOutValue int64, revealTagScript []byte, feeRate int64) (int64, error) {
 	var revealTx []*wire.MsgTx
 	totalPrevOutput := int64(0)
 	total := len(tool.txCtxDataList)
@@ -197,14 +263,26 @@ func (tool *InscriptionTool) buildEmptyRevealTx(singleRevealTxOnly bool, destina
 		if err != nil {
 			return err
 		}
+		if !receiver.IsForNet(tool.net) {
+			return fmt.Errorf("reveal destination %s is not a %s address", destination[index], tool.net.Name)
+		}
 		scriptPubKey, err := txscript.PayToAddrScript(receiver)
 		if err != nil {
 			return err
 		}
 		out := wire.NewTxOut(revealOutValue, scriptPubKey)
+		if mempool.IsDust(out, mempool.DefaultMinRelayTxFee) {
+			return fmt.Errorf("reveal output value %d is dust for %s", revealOutValue, destination[index])
+		}
 		tx.AddTxOut(out)
 		return nil
 	}
+	// the tag output follows the inscription outputs
+	addRevealTagOutput := func(tx *wire.MsgTx) {
+		if revealTagScript != nil {
+			tx.AddTxOut(wire.NewTxOut(0, revealTagScript))
+		}
+	}
 	if singleRevealTxOnly {
 		revealTx = make([]*wire.MsgTx, 1)
 		tx := wire.NewMsgTx(wire.TxVersion)
@@ -214,6 +292,7 @@ func (tool *InscriptionTool) buildEmptyRevealTx(singleRevealTxOnly bool, destina
 				return 0, err
 			}
 		}
+		addRevealTagOutput(tx)
 		eachRevealBaseTxFee := int64(tx.SerializeSize()) * feeRate / int64(total)
 		prevOutput := (revealOutValue + eachRevealBaseTxFee) * int64(total)
 		{
@@ -238,6 +317,7 @@ func (tool *InscriptionTool) buildEmptyRevealTx(singleRevealTxOnly bool, destina
 			if err != nil {
 				return 0, err
 			}
+			addRevealTagOutput(tx)
 			prevOutput := revealOutValue + int64(tx.SerializeSize())*feeRate
 			{
 				emptySignature := make([]byte, 64)
@@ -285,6 +365,7 @@ func (tool *InscriptionTool) buildCommitTx(commitTxOutPointList []*wire.OutPoint
 	totalSenderAmount := btcutil.Amount(0)
 	tx := wire.NewMsgTx(wire.TxVersion)
 	var changePkScript *[]byte
+	pkScripts := make([][]byte, 0, len(commitTxOutPointList))
 	for i := range commitTxOutPointList {
 		txOut, err := tool.getTxOutByOutPoint(commitTxOutPointList[i])
 		if err != nil {
@@ -296,6 +377,7 @@ func (tool *InscriptionTool) buildCommitTx(commitTxOutPointList []*wire.OutPoint
 		in := wire.NewTxIn(commitTxOutPointList[i], nil, nil)
 		in.Sequence = defaultSequenceNum
 		tx.AddTxIn(in)
+		pkScripts = append(pkScripts, txOut.PkScript)
 
 		totalSenderAmount += btcutil.Amount(txOut.Value)
 	}
@@ -303,15 +385,22 @@ func (tool *InscriptionTool) buildCommitTx(commitTxOutPointList []*wire.OutPoint
 		tx.AddTxOut(tool.txCtxDataList[i].revealTxPrevOutput)
 	}
 
+	// the change goes back to the wallet address, the sender may be any of the keychain spendable scripts
+	if tool.client.keychain != nil && len(tool.commitTxPrivateKeyList) == 0 {
+		walletPkScript := tool.client.keychain.GetPkScript()
+		changePkScript = &walletPkScript
+	}
 	tx.AddTxOut(wire.NewTxOut(0, *changePkScript))
-	fee := btcutil.Amount(mempool.GetTxVirtualSize(btcutil.NewTx(tx))) * btcutil.Amount(commitFeeRate)
+	// the fee is paid for the signed size of the inputs, as budgeted by the coin selection
+	fee := btcutil.Amount(signedVSize(tx, pkScripts, tool.client.keychain)) * btcutil.Amount(commitFeeRate)
 	changeAmount := totalSenderAmount - btcutil.Amount(totalRevealPrevOutput) - fee
-	if changeAmount > 0 {
+	// a dust change is left to the fee
+	if changeAmount > 0 && !mempool.IsDust(wire.NewTxOut(int64(changeAmount), *changePkScript), mempool.DefaultMinRelayTxFee) {
 		tx.TxOut[len(tx.TxOut)-1].Value = int64(changeAmount)
 	} else {
 		tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
 		if changeAmount < 0 {
-			feeWithoutChange := btcutil.Amount(mempool.GetTxVirtualSize(btcutil.NewTx(tx))) * btcutil.Amount(commitFeeRate)
+			feeWithoutChange := btcutil.Amount(signedVSize(tx, pkScripts, tool.client.keychain)) * btcutil.Amount(commitFeeRate)
 			if totalSenderAmount-btcutil.Amount(totalRevealPrevOutput)-feeWithoutChange < 0 {
 				return errors.New("insufficient balance")
 			}
@@ -371,7 +460,7 @@ func (tool *InscriptionTool) completeRevealTx() error {
 
 func (tool *InscriptionTool) signCommitTx() error {
 	if len(tool.commitTxPrivateKeyList) == 0 {
-		err := tool.client.keychain.SignTransaction(tool.commitTx, tool.client.indexerClient)
+		err := tool.client.keychain.SignTransaction(tool.commitTx, tool.commitTxPrevOutputFetcher)
 		if err != nil {
 			return err
 		}
@@ -441,6 +530,55 @@ func (tool *InscriptionTool) calculateFee() int64 {
 	return fees
 }
 
+// Estimate returns the sizes and the fees of the built transactions
+func (tool *InscriptionTool) Estimate() *InscriptionEstimate {
+	estimate := &InscriptionEstimate{
+		CommitTx:  newTxEstimate(tool.commitTx, tool.commitTxPrevOutputFetcher),
+		RevealTxs: make([]TxEstimate, len(tool.revealTx)),
+		Inputs:    make([]wire.OutPoint, len(tool.commitTx.TxIn)),
+	}
+	for i, in := range tool.commitTx.TxIn {
+		estimate.Inputs[i] = in.PreviousOutPoint
+		estimate.InputValue += tool.commitTxPrevOutputFetcher.FetchPrevOutput(in.PreviousOutPoint).Value
+	}
+	// the change is the output following the reveal outputs
+	if len(tool.commitTx.TxOut) > len(tool.txCtxDataList) {
+		estimate.Change = tool.commitTx.TxOut[len(tool.commitTx.TxOut)-1].Value
+	}
+	estimate.Fees = estimate.CommitTx.Fee
+	for i, tx := range tool.revealTx {
+		estimate.RevealTxs[i] = newTxEstimate(tx, tool.revealTxPrevOutputFetcher)
+		estimate.Fees += estimate.RevealTxs[i].Fee
+	}
+	return estimate
+}
+
+// newTxEstimate returns the size and the fee of a signed transaction
+func newTxEstimate(tx *wire.MsgTx, prevOutputFetcher txscript.PrevOutputFetcher) TxEstimate {
+	fee := int64(0)
+	for _, in := range tx.TxIn {
+		fee += prevOutputFetcher.FetchPrevOutput(in.PreviousOutPoint).Value
+	}
+	for _, out := range tx.TxOut {
+		fee -= out.Value
+	}
+	btcTx := btcutil.NewTx(tx)
+	return TxEstimate{
+		TxHash: tx.TxHash(),
+		VSize:  mempool.GetTxVirtualSize(btcTx),
+		Weight: blockchain.GetTransactionWeight(btcTx),
+		Fee:    fee,
+	}
+}
+
+// revealFee returns the part of the reveal fee paid by the inscription at index i
+func (tool *InscriptionTool) revealFee(i int) int64 {
+	if len(tool.revealTx) == 1 {
+		return tool.txCtxDataList[i].revealTxPrevOutput.Value - tool.revealTx[0].TxOut[i].Value
+	}
+	return tool.txCtxDataList[i].revealTxPrevOutput.Value - tool.revealTx[i].TxOut[0].Value
+}
+
 func (tool *InscriptionTool) Inscribe() (commitTxHash *chainhash.Hash, revealTxHashList []*chainhash.Hash, inscriptions []string, fees int64, err error) {
 	fees = tool.calculateFee()
 	commitTxHash, err = tool.sendRawTransaction(tool.commitTx)
@@ -462,7 +600,7 @@ func (tool *InscriptionTool) Inscribe() (commitTxHash *chainhash.Hash, revealTxH
 		}
 	}
 	if len(tool.revealTx) != len(tool.txCtxDataList) {
-		for i := len(inscriptions) - 1; i > 0; i-- {
+		for i := len(inscriptions) - 1; i >= 0; i-- {
 			inscriptions[i] = fmt.Sprintf("%s%d", inscriptions[0], i)
 		}
 	}
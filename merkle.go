@@ -0,0 +1,141 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// ErrInvalidMerkleProof is returned when the merkle branch of a proof doesn't lead to the merkle root of its header
+var ErrInvalidMerkleProof = errors.New("invalid merkle proof")
+
+// MerkleProof proves a transaction is included in a block, it's verified by Verify without network access
+type MerkleProof struct {
+	TxHash chainhash.Hash
+	Height int32
+	// Position is the index of the transaction in the block
+	Position int
+	// Branch are the hashes of the merkle tree siblings, from the transaction up to the root
+	Branch []chainhash.Hash
+	Header wire.BlockHeader
+}
+
+// merkleProofJSON is the serialized merkle proof, the hashes are in the usual reversed hex
+type merkleProofJSON struct {
+	TxHash   chainhash.Hash   `json:"txid"`
+	Height   int32            `json:"height"`
+	Position int              `json:"position"`
+	Branch   []chainhash.Hash `json:"branch"`
+	Header   string           `json:"header"`
+}
+
+// MarshalJSON serializes the proof with the header as hex
+func (p MerkleProof) MarshalJSON() ([]byte, error) {
+	var header bytes.Buffer
+	if err := p.Header.Serialize(&header); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merkleProofJSON{
+		TxHash:   p.TxHash,
+		Height:   p.Height,
+		Position: p.Position,
+		Branch:   p.Branch,
+		Header:   hex.EncodeToString(header.Bytes()),
+	})
+}
+
+// UnmarshalJSON deserializes a proof serialized by MarshalJSON
+func (p *MerkleProof) UnmarshalJSON(data []byte) error {
+	var proof merkleProofJSON
+	if err := json.Unmarshal(data, &proof); err != nil {
+		return err
+	}
+	header, err := deserializeBlockHeader(proof.Header)
+	if err != nil {
+		return fmt.Errorf("invalid proof header: %v", err)
+	}
+	*p = MerkleProof{
+		TxHash:   proof.TxHash,
+		Height:   proof.Height,
+		Position: proof.Position,
+		Branch:   proof.Branch,
+		Header:   *header,
+	}
+	return nil
+}
+
+// MerkleRoot returns the merkle root computed from the transaction hash and the branch
+func (p *MerkleProof) MerkleRoot() chainhash.Hash {
+	root := p.TxHash
+	for i, sibling := range p.Branch {
+		var pair [chainhash.HashSize * 2]byte
+		if (p.Position>>i)&1 == 1 {
+			copy(pair[:], sibling[:])
+			copy(pair[chainhash.HashSize:], root[:])
+		} else {
+			copy(pair[:], root[:])
+			copy(pair[chainhash.HashSize:], sibling[:])
+		}
+		root = chainhash.DoubleHashH(pair[:])
+	}
+	return root
+}
+
+// Verify checks the branch leads from the transaction to the merkle root of the header.
+// The header itself must be checked against the chain, e.g. by the hash of the block at the proof height
+func (p *MerkleProof) Verify() error {
+	if p.Position < 0 || p.Position>>len(p.Branch) != 0 {
+		return fmt.Errorf("%w: position %d out of a branch of %d hashes", ErrInvalidMerkleProof, p.Position, len(p.Branch))
+	}
+	if root := p.MerkleRoot(); root != p.Header.MerkleRoot {
+		return fmt.Errorf("%w: merkle root %s, header merkle root %s", ErrInvalidMerkleProof, root, p.Header.MerkleRoot)
+	}
+	return nil
+}
+
+// GetMerkleProof returns the verified proof of the inclusion of a transaction in the block at height.
+// The header is verified against the header chain if a header checkpoint is configured
+func (client *Client) GetMerkleProof(txid string, height int32) (*MerkleProof, error) {
+	txHash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+	merkle, err := client.IndexerClient.GetMerkle(context.Background(), txid, height)
+	if err != nil {
+		return nil, err
+	}
+	if merkle.BlockHeight != height {
+		return nil, fmt.Errorf("transaction %s is at height %d, not %d", txid, merkle.BlockHeight, height)
+	}
+
+	branch := make([]chainhash.Hash, len(merkle.Merkle))
+	for i, siblingHex := range merkle.Merkle {
+		sibling, err := chainhash.NewHashFromStr(siblingHex)
+		if err != nil {
+			return nil, err
+		}
+		branch[i] = *sibling
+	}
+	header, err := client.GetBlockHeader(uint64(height))
+	if err != nil {
+		return nil, err
+	}
+
+	proof := &MerkleProof{
+		TxHash:   *txHash,
+		Height:   height,
+		Position: merkle.Pos,
+		Branch:   branch,
+		Header:   *header,
+	}
+	if err := proof.Verify(); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
@@ -0,0 +1,90 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// merkleBranch returns the merkle root of the transactions and the branch of the transaction at position
+func merkleBranch(txHashes []chainhash.Hash, position int) (chainhash.Hash, []string) {
+	branch := []string{}
+	level := txHashes
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		branch = append(branch, level[position^1].String())
+		next := []chainhash.Hash{}
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, chainhash.DoubleHashH(append(level[i][:], level[i+1][:]...)))
+		}
+		level = next
+		position /= 2
+	}
+	return level[0], branch
+}
+
+func TestGetMerkleProof(t *testing.T) {
+	txHashes := []chainhash.Hash{}
+	for i := 0; i < 5; i++ {
+		txHashes = append(txHashes, chainhash.HashH([]byte(fmt.Sprintf("tx %d", i))))
+	}
+	root, branch := merkleBranch(txHashes, 4)
+	header := wire.BlockHeader{Version: 4, MerkleRoot: root}
+	var buf bytes.Buffer
+	require.NoError(t, header.Serialize(&buf))
+
+	mockIndexer := new(mocks.Indexer)
+	mockIndexer.On("GetMerkle", mock.Anything, txHashes[4].String(), int32(100)).Return(&indexer.Merkle{BlockHeight: 100, Merkle: branch, Pos: 4}, nil)
+	mockIndexer.On("GetMerkle", mock.Anything, txHashes[3].String(), int32(100)).Return(&indexer.Merkle{BlockHeight: 100, Merkle: branch, Pos: 3}, nil)
+	mockIndexer.On("GetBlockHeader", mock.Anything, uint64(100)).Return(hex.EncodeToString(buf.Bytes()), nil)
+	client := &Client{IndexerClient: mockIndexer}
+
+	proof, err := client.GetMerkleProof(txHashes[4].String(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, txHashes[4], proof.TxHash)
+	assert.Len(t, proof.Branch, 3)
+	assert.Equal(t, header.BlockHash(), proof.Header.BlockHash())
+
+	// the serialized proof is verified without the indexer
+	data, err := json.Marshal(proof)
+	require.NoError(t, err)
+	var decoded MerkleProof
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, proof.TxHash, decoded.TxHash)
+	assert.Equal(t, proof.Position, decoded.Position)
+	assert.Equal(t, proof.Branch, decoded.Branch)
+	assert.Equal(t, proof.Header.BlockHash(), decoded.Header.BlockHash())
+	require.NoError(t, decoded.Verify())
+
+	decoded.Position = 0
+	assert.ErrorIs(t, decoded.Verify(), ErrInvalidMerkleProof)
+	decoded.Position = 8
+	assert.ErrorIs(t, decoded.Verify(), ErrInvalidMerkleProof)
+
+	// the branch of another transaction
+	_, err = client.GetMerkleProof(txHashes[3].String(), 100)
+	assert.ErrorIs(t, err, ErrInvalidMerkleProof)
+}
+
+func TestGetMerkleProofWrongHeight(t *testing.T) {
+	txHash := chainhash.HashH([]byte("tx"))
+	mockIndexer := new(mocks.Indexer)
+	mockIndexer.On("GetMerkle", context.Background(), txHash.String(), int32(100)).Return(&indexer.Merkle{BlockHeight: 101}, nil)
+	client := &Client{IndexerClient: mockIndexer}
+
+	_, err := client.GetMerkleProof(txHash.String(), 100)
+	assert.Error(t, err)
+}
@@ -5,7 +5,6 @@ import (
 
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/grail-rollup/btcman/indexer"
@@ -16,28 +15,46 @@ type Indexer struct {
 }
 
 func (m *Indexer) Start(string) {}
-func (m *Indexer) ListUnspent(context.Context, *secp256k1.PublicKey) ([]*indexer.UTXO, error) {
-	return nil, nil
+func (m *Indexer) ListUnspent(ctx context.Context, pkScript []byte) ([]*indexer.UTXO, error) {
+	args := m.Called(ctx, pkScript)
+	return args.Get(0).([]*indexer.UTXO), args.Error(1)
 }
-func (m *Indexer) GetHistory(ctx context.Context, publicKey *secp256k1.PublicKey) ([]*indexer.Transaction, error) {
-	args := m.Called(ctx, publicKey)
+func (m *Indexer) GetHistory(ctx context.Context, pkScript []byte) ([]*indexer.Transaction, error) {
+	args := m.Called(ctx, pkScript)
 	return args.Get(0).([]*indexer.Transaction), args.Error(1)
 }
-func (m *Indexer) GetTransaction(context.Context, string, bool) (*btcjson.TxRawResult, error) {
-	return nil, nil
+func (m *Indexer) GetBalance(ctx context.Context, pkScript []byte) (*indexer.Balance, error) {
+	args := m.Called(ctx, pkScript)
+	return args.Get(0).(*indexer.Balance), args.Error(1)
+}
+func (m *Indexer) GetTransaction(ctx context.Context, txID string, verbose bool) (*btcjson.TxRawResult, error) {
+	args := m.Called(ctx, txID, verbose)
+	return args.Get(0).(*btcjson.TxRawResult), args.Error(1)
 }
 func (m *Indexer) GetBlockchainInfo(ctx context.Context) (*indexer.BlockChainInfo, error) {
 	args := m.Called(ctx)
 	return args.Get(0).(*indexer.BlockChainInfo), args.Error(1)
 }
 func (m *Indexer) SendTransaction(ctx context.Context, transactionHex *wire.MsgTx) (string, error) {
-	return "", nil
+	args := m.Called(ctx, transactionHex)
+	if txHash, ok := args.Get(0).(func(*wire.MsgTx) string); ok {
+		return txHash(transactionHex), args.Error(1)
+	}
+	return args.String(0), args.Error(1)
 }
-func (m *Indexer) GetLastInscribedTransactionsByPublicKey(ctx context.Context, publicKey *secp256k1.PublicKey, blockchainHeight int32, utxoThreshold float64) ([]*indexer.TxInfo, error) {
+func (m *Indexer) GetLastInscribedTransactionsByPublicKey(ctx context.Context, pkScript []byte, blockchainHeight int32, utxoThreshold float64) ([]*indexer.TxInfo, error) {
 	return nil, nil
 }
 func (m *Indexer) GetBlockHeader(ctx context.Context, height uint64) (string, error) {
 	args := m.Called(ctx, height)
 	return args.Get(0).(string), args.Error(1)
 }
+func (m *Indexer) GetBlockHeaders(ctx context.Context, startHeight uint64, count int) (*indexer.BlockHeaders, error) {
+	args := m.Called(ctx, startHeight, count)
+	return args.Get(0).(*indexer.BlockHeaders), args.Error(1)
+}
+func (m *Indexer) GetMerkle(ctx context.Context, txID string, height int32) (*indexer.Merkle, error) {
+	args := m.Called(ctx, txID, height)
+	return args.Get(0).(*indexer.Merkle), args.Error(1)
+}
 func (m *Indexer) Disconnect() {}
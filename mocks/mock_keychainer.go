@@ -1,9 +1,10 @@
 package mocks
 
 import (
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/decred/dcrd/dcrec/secp256k1/v4"
-	"github.com/grail-rollup/btcman/indexer"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -11,10 +12,22 @@ type Keychainer struct {
 	mock.Mock
 }
 
-func (m *Keychainer) SignTransaction(rawTransaction *wire.MsgTx, indexer indexer.Indexerer) error {
+func (m *Keychainer) SignTransaction(rawTransaction *wire.MsgTx, prevOutFetcher txscript.PrevOutputFetcher) error {
 	return nil
 }
 
 func (m *Keychainer) GetPublicKey() *secp256k1.PublicKey {
 	return nil
 }
+
+func (m *Keychainer) GetAddress() btcutil.Address {
+	return nil
+}
+
+func (m *Keychainer) GetPkScript() []byte {
+	return nil
+}
+
+func (m *Keychainer) GetPkScripts() [][]byte {
+	return nil
+}
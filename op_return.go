@@ -0,0 +1,283 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+)
+
+// PublishMode selects how the data is written on chain
+type PublishMode string
+
+const (
+	// InscriptionPublish writes the data in an ordinals inscription revealed by a commit and reveal transaction
+	InscriptionPublish PublishMode = ""
+	// OpReturnPublish writes the data in the OP_RETURN outputs of a single transaction, suited for small payloads
+	OpReturnPublish PublishMode = "op-return"
+)
+
+const (
+	// opReturnFeeRate is the fee rate of the OP_RETURN transactions in sat/vbyte
+	opReturnFeeRate = 3
+	// defaultOpReturnMaxOutputs is the number of OP_RETURN outputs relayed by nodes with the standard policy
+	defaultOpReturnMaxOutputs = 1
+)
+
+// ErrNoOpReturnData is returned when a transaction has no OP_RETURN output
+var ErrNoOpReturnData = errors.New("transaction has no OP_RETURN data")
+
+// opReturnTx is a signed transaction publishing data in its OP_RETURN outputs
+type opReturnTx struct {
+	tx                *wire.MsgTx
+	prevOutputFetcher txscript.PrevOutputFetcher
+}
+
+// opReturnOutputs splits data in OP_RETURN outputs of at most OpReturnMaxSize bytes each
+func (client *Client) opReturnOutputs(data []byte) ([]*wire.TxOut, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no data to publish")
+	}
+	maxSize := MaxStandardOpReturnSize
+	if client.cfg.OpReturnMaxSize > 0 {
+		maxSize = client.cfg.OpReturnMaxSize
+	}
+	if maxSize > txscript.MaxScriptElementSize {
+		return nil, fmt.Errorf("OP_RETURN size limit %d exceeds the script element limit of %d bytes", maxSize, txscript.MaxScriptElementSize)
+	}
+	maxOutputs := defaultOpReturnMaxOutputs
+	if client.cfg.OpReturnMaxOutputs > 0 {
+		maxOutputs = client.cfg.OpReturnMaxOutputs
+	}
+	if len(data) > maxSize*maxOutputs {
+		return nil, fmt.Errorf("data size %d exceeds the OP_RETURN limit of %d outputs of %d bytes", len(data), maxOutputs, maxSize)
+	}
+
+	txOuts := []*wire.TxOut{}
+	for start := 0; start < len(data); start += maxSize {
+		end := start + maxSize
+		if end > len(data) {
+			end = len(data)
+		}
+		script, err := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddData(data[start:end]).Script()
+		if err != nil {
+			return nil, err
+		}
+		txOuts = append(txOuts, wire.NewTxOut(0, script))
+	}
+	return txOuts, nil
+}
+
+// buildOpReturnTx funds and signs a transaction publishing data in OP_RETURN outputs,
+// the leases of its inputs must be released if it isn't sent
+func (client *Client) buildOpReturnTx(data []byte, opts InscribeOptions) (*opReturnTx, error) {
+	if opts.Chunked || opts.RevealTag != nil || opts.Destination != "" || opts.RevealOutValue != 0 {
+		return nil, errors.New("OP_RETURN publishing has no reveal outputs and can't be chunked")
+	}
+	txOuts, err := client.opReturnOutputs(data)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	for _, txOut := range txOuts {
+		tx.AddTxOut(txOut)
+	}
+	changeOutput := wire.NewTxOut(0, client.keychain.GetPkScript())
+	target := CoinSelectionTarget{
+		Amount:     mempool.GetTxVirtualSize(btcutil.NewTx(tx)) * opReturnFeeRate,
+		FeeRate:    opReturnFeeRate,
+		ChangeCost: int64(changeOutput.SerializeSize()) * opReturnFeeRate,
+	}
+	utxos, err := client.leaseUTXOs(target, client.utxoThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	totalAmount := int64(0)
+	for _, utxo := range utxos {
+		outPoint, err := utxoOutPoint(utxo)
+		if err != nil {
+			client.utxoManager.release(outPointsOf(utxos)...)
+			return nil, err
+		}
+		tx.AddTxIn(wire.NewTxIn(&outPoint, nil, nil))
+		totalAmount += utxo.Value
+	}
+
+	// the unsigned inputs are counted with their estimated witness
+	tx.AddTxOut(changeOutput)
+	fee := client.estimatedVSize(tx, utxos) * opReturnFeeRate
+	changeOutput.Value = totalAmount - fee
+	// a dust change is left to the fee
+	if mempool.IsDust(changeOutput, mempool.DefaultMinRelayTxFee) {
+		tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
+		if totalAmount < client.estimatedVSize(tx, utxos)*opReturnFeeRate {
+			client.utxoManager.release(outPointsOf(utxos)...)
+			return nil, errors.New("insufficient balance")
+		}
+	}
+
+	keychain := client.keychain
+	if opts.DryRun {
+		keychain = client.dryRunKeychain()
+	}
+	prevOutputFetcher := NewUtxoPrevOutFetcher(utxos, client.IndexerClient, client.logger)
+	if err := keychain.SignTransaction(tx, prevOutputFetcher); err != nil {
+		client.utxoManager.release(outPointsOf(utxos)...)
+		return nil, err
+	}
+	return &opReturnTx{tx: tx, prevOutputFetcher: prevOutputFetcher}, nil
+}
+
+// publishOpReturn sends a transaction publishing data in OP_RETURN outputs. The transaction is reported
+// as the only reveal transaction of the result, there is no commit transaction and no inscription ID
+func (client *Client) publishOpReturn(data []byte, opts InscribeOptions) (*InscribeResult, error) {
+	opReturn, err := client.buildOpReturnTx(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	estimate := newTxEstimate(opReturn.tx, opReturn.prevOutputFetcher)
+
+	if opts.DryRun {
+		client.utxoManager.release(txOutPoints(opReturn.tx)...)
+		txHex, err := indexer.GetTxHex(opReturn.tx)
+		if err != nil {
+			return nil, err
+		}
+		result := newOpReturnResult(&estimate)
+		result.RevealTxHexList = []string{txHex}
+		result.Signed = client.dryRunSigned()
+		return result, nil
+	}
+
+	txHash, err := client.IndexerClient.SendTransaction(context.Background(), opReturn.tx)
+	if err != nil {
+		client.utxoManager.release(txOutPoints(opReturn.tx)...)
+		return nil, err
+	}
+	client.utxoManager.spend(opReturn.tx, client.keychain.GetPkScripts())
+	hash, err := chainhash.NewHashFromStr(txHash)
+	if err != nil {
+		return nil, err
+	}
+	estimate.TxHash = *hash
+
+	client.logger.Info("Data published successfully", "txHash", txHash, "size", len(data), "fees", estimate.Fee)
+	return newOpReturnResult(&estimate), nil
+}
+
+// newOpReturnResult returns the result of an OP_RETURN transaction
+func newOpReturnResult(estimate *TxEstimate) *InscribeResult {
+	txHash := estimate.TxHash
+	return &InscribeResult{
+		RevealTxHashList: []*chainhash.Hash{&txHash},
+		Fees:             estimate.Fee,
+		Items: []InscribeItemResult{{
+			RevealTxHash: &txHash,
+			RevealFee:    estimate.Fee,
+		}},
+		Signed: true,
+	}
+}
+
+// estimateOpReturn returns the size and fee of the transaction publishing data in OP_RETURN outputs
+func (client *Client) estimateOpReturn(data []byte, opts InscribeOptions) (*InscriptionEstimate, error) {
+	opReturn, err := client.buildOpReturnTx(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	client.utxoManager.release(txOutPoints(opReturn.tx)...)
+
+	estimate := &InscriptionEstimate{
+		RevealTxs: []TxEstimate{newTxEstimate(opReturn.tx, opReturn.prevOutputFetcher)},
+		Inputs:    txOutPoints(opReturn.tx),
+	}
+	for _, txIn := range opReturn.tx.TxIn {
+		estimate.InputValue += opReturn.prevOutputFetcher.FetchPrevOutput(txIn.PreviousOutPoint).Value
+	}
+	for _, txOut := range opReturn.tx.TxOut {
+		if bytes.Equal(txOut.PkScript, client.keychain.GetPkScript()) {
+			estimate.Change += txOut.Value
+		}
+	}
+	estimate.Fees = estimate.RevealTxs[0].Fee
+	return estimate, nil
+}
+
+// DecodeOpReturnData reads the data published in the OP_RETURN outputs of a transaction from BTC by a transaction hash
+func (client *Client) DecodeOpReturnData(txHash string) ([]byte, error) {
+	tx, err := client.getMsgTx(txHash)
+	if err != nil {
+		return nil, err
+	}
+	return ParseOpReturnData(tx)
+}
+
+// ParseOpReturnData returns the concatenated data pushes of the OP_RETURN outputs of a transaction, in output order
+func ParseOpReturnData(tx *wire.MsgTx) ([]byte, error) {
+	data := []byte{}
+	found := false
+	for _, txOut := range tx.TxOut {
+		// larger outputs of relaxed policies are not classified as null data, the opcode is checked instead
+		if len(txOut.PkScript) == 0 || txOut.PkScript[0] != txscript.OP_RETURN {
+			continue
+		}
+		pushes, err := txscript.PushedData(txOut.PkScript)
+		if err != nil {
+			return nil, err
+		}
+		for _, push := range pushes {
+			data = append(data, push...)
+		}
+		found = true
+	}
+	if !found {
+		return nil, ErrNoOpReturnData
+	}
+	return data, nil
+}
+
+// estimatedVSize is the virtual size of an unsigned transaction once its inputs spending utxos are signed
+func (client *Client) estimatedVSize(tx *wire.MsgTx, utxos []*indexer.UTXO) int64 {
+	pkScripts := make([][]byte, len(utxos))
+	for i, utxo := range utxos {
+		pkScripts[i] = utxo.PkScript
+	}
+	return signedVSize(tx, pkScripts, client.keychain)
+}
+
+// signedVSize is the virtual size of an unsigned transaction once its inputs spending pkScripts are signed by the keychain
+func signedVSize(tx *wire.MsgTx, pkScripts [][]byte, keychain Keychainer) int64 {
+	// an unsigned input is an outpoint, an empty script and a sequence
+	const unsignedInputSize = 41
+	vsize := mempool.GetTxVirtualSize(btcutil.NewTx(tx))
+	hasWitness := false
+	for _, pkScript := range pkScripts {
+		vsize += keychainInputVSize(keychain, pkScript) - unsignedInputSize
+		hasWitness = hasWitness || txscript.IsWitnessProgram(pkScript)
+	}
+	// the segwit marker and flag of a signed transaction, rounded up to a vbyte
+	if hasWitness && !tx.HasWitness() {
+		vsize++
+	}
+	return vsize
+}
+
+// outPointsOf returns the outpoints of utxos
+func outPointsOf(utxos []*indexer.UTXO) []wire.OutPoint {
+	outPoints := []wire.OutPoint{}
+	for _, utxo := range utxos {
+		if outPoint, err := utxoOutPoint(utxo); err == nil {
+			outPoints = append(outPoints, outPoint)
+		}
+	}
+	return outPoints
+}
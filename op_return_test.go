@@ -0,0 +1,128 @@
+package btcman
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishOpReturn(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 100_000)
+	sent := []*wire.MsgTx{}
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return(func(tx *wire.MsgTx) string {
+		sent = append(sent, tx)
+		return tx.TxHash().String()
+	}, nil)
+	data := []byte("state root 0123456789abcdef0123456789abcdef")
+
+	result, err := btcman.InscribeWithOptions(data, InscribeOptions{PublishMode: OpReturnPublish})
+	require.NoError(t, err)
+	require.Len(t, sent, 1)
+	tx := sent[0]
+	assert.Nil(t, result.CommitTxHash)
+	assert.Empty(t, result.InscriptionIDs)
+	assert.Equal(t, tx.TxHash(), *result.RevealTxHashList[0])
+	assert.Positive(t, result.Fees)
+
+	require.Len(t, tx.TxOut, 2)
+	assert.Equal(t, txscript.NullDataTy, txscript.GetScriptClass(tx.TxOut[0].PkScript))
+	assert.Equal(t, btcman.keychain.GetPkScript(), tx.TxOut[1].PkScript)
+	assert.EqualValues(t, 100_000-result.Fees, tx.TxOut[1].Value)
+	assert.NotEmpty(t, tx.TxIn[0].Witness)
+
+	txHex, err := indexer.GetTxHex(tx)
+	require.NoError(t, err)
+	mockIndexer.On("GetTransaction", mock.Anything, tx.TxHash().String(), false).Return(&btcjson.TxRawResult{Hex: txHex}, nil)
+	decoded, err := btcman.DecodeOpReturnData(tx.TxHash().String())
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+
+	// the utxo is spent by the sent transaction and its unconfirmed change isn't allowed
+	utxos, err := btcman.spendableUTXOs()
+	require.NoError(t, err)
+	assert.Empty(t, utxos)
+}
+
+func TestPublishOpReturnLimits(t *testing.T) {
+	data := bytes.Repeat([]byte{0xab}, 300)
+
+	t.Run("standard limit", func(t *testing.T) {
+		mockIndexer := new(mocks.Indexer)
+		btcman := newTestWriterClient(t, mockIndexer, 100_000)
+
+		_, err := btcman.InscribeWithOptions(data, InscribeOptions{PublishMode: OpReturnPublish, DryRun: true})
+		assert.Error(t, err)
+		_, err = btcman.InscribeWithOptions(data[:MaxStandardOpReturnSize], InscribeOptions{PublishMode: OpReturnPublish, DryRun: true})
+		assert.NoError(t, err)
+	})
+
+	t.Run("relaxed limit", func(t *testing.T) {
+		mockIndexer := new(mocks.Indexer)
+		btcman := newTestWriterClient(t, mockIndexer, 100_000)
+		btcman.cfg.OpReturnMaxSize = 200
+		btcman.cfg.OpReturnMaxOutputs = 2
+
+		result, err := btcman.InscribeWithOptions(data, InscribeOptions{PublishMode: OpReturnPublish, DryRun: true})
+		require.NoError(t, err)
+		mockIndexer.AssertNotCalled(t, "SendTransaction", mock.Anything, mock.Anything)
+		assert.True(t, result.Signed)
+		tx, err := deserializeTransaction(result.RevealTxHexList[0])
+		require.NoError(t, err)
+		require.Len(t, tx.TxOut, 3)
+		decoded, err := ParseOpReturnData(tx)
+		require.NoError(t, err)
+		assert.Equal(t, data, decoded)
+
+		// the dry run doesn't keep the utxo leased
+		utxos, err := btcman.spendableUTXOs()
+		require.NoError(t, err)
+		assert.Len(t, utxos, 1)
+	})
+
+	t.Run("inscription options", func(t *testing.T) {
+		mockIndexer := new(mocks.Indexer)
+		btcman := newTestWriterClient(t, mockIndexer, 100_000)
+
+		_, err := btcman.InscribeWithOptions(data[:10], InscribeOptions{PublishMode: OpReturnPublish, Chunked: true})
+		assert.Error(t, err)
+		_, err = btcman.InscribeBatch([][]byte{data[:10]}, InscribeOptions{PublishMode: OpReturnPublish})
+		assert.Error(t, err)
+	})
+}
+
+func TestEstimateOpReturn(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 100_000)
+
+	estimate, err := btcman.EstimateInscription([]byte("checkpoint"), InscribeOptions{PublishMode: OpReturnPublish})
+	require.NoError(t, err)
+	assert.Zero(t, estimate.CommitTx)
+	require.Len(t, estimate.RevealTxs, 1)
+	assert.EqualValues(t, 100_000, estimate.InputValue)
+	assert.Equal(t, estimate.InputValue-estimate.Fees, estimate.Change)
+	// the estimated witness is close to the signed one
+	assert.InDelta(t, estimate.RevealTxs[0].VSize*opReturnFeeRate, estimate.Fees, 3)
+}
+
+func TestParseOpReturnData(t *testing.T) {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	_, err := ParseOpReturnData(tx)
+	assert.ErrorIs(t, err, ErrNoOpReturnData)
+
+	script, err := txscript.NullDataScript([]byte("data"))
+	require.NoError(t, err)
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+	tx.AddTxOut(wire.NewTxOut(0, script))
+	data, err := ParseOpReturnData(tx)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+}
@@ -0,0 +1,419 @@
+package btcman
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/common"
+	"github.com/ledgerwatch/log/v3"
+)
+
+const (
+	defaultQueueMaxBatchSize  = 16
+	defaultQueueMaxBatchBytes = DefaultChunkSize
+	defaultQueueMaxDelay      = time.Minute
+	defaultQueueConfirmations = 1
+	defaultQueuePollInterval  = 30 * time.Second
+	defaultQueueTicketTTL     = time.Hour
+	defaultQueueSeenTimeout   = 10 * time.Minute
+)
+
+var (
+	// ErrQueueStopped is returned when submitting to a stopped queue and set on the payloads unfinished when it stopped
+	ErrQueueStopped = errors.New("inscription queue is stopped")
+	// ErrRevealNotSeen is set on the payloads whose reveal transaction the indexer didn't return within the seen timeout
+	ErrRevealNotSeen = errors.New("reveal transaction not seen by the indexer")
+)
+
+// Ticket identifies a payload submitted to the inscription queue
+type Ticket uint64
+
+// TicketStatus is the progress of a submitted payload
+type TicketStatus string
+
+const (
+	// TicketPending is a payload waiting to be batched
+	TicketPending TicketStatus = "pending"
+	// TicketBroadcast is a payload whose reveal transaction is sent and waits for confirmations
+	TicketBroadcast TicketStatus = "broadcast"
+	// TicketConfirmed is a payload whose reveal transaction reached the required confirmations
+	TicketConfirmed TicketStatus = "confirmed"
+	// TicketFailed is a payload that couldn't be inscribed, or whose reveal transaction was dropped or double spent
+	TicketFailed TicketStatus = "failed"
+)
+
+// TicketState is the state of a submitted payload
+type TicketState struct {
+	Ticket        Ticket
+	Status        TicketStatus
+	CommitTxHash  *chainhash.Hash
+	RevealTxHash  *chainhash.Hash
+	InscriptionID string
+	Confirmations int64
+	Err           error
+}
+
+// QueueConfig are the limits of the inscription queue, zero values are replaced by defaults
+type QueueConfig struct {
+	// MaxBatchSize is the maximum number of payloads inscribed by one commit transaction
+	MaxBatchSize int
+	// MaxBatchBytes is the maximum size of the payloads of a batch, larger payloads are rejected on submit
+	MaxBatchBytes int
+	// MaxDelay is the maximum time a payload waits for a batch to fill up
+	MaxDelay time.Duration
+	// Confirmations is the number of confirmations after which a payload is confirmed
+	Confirmations int64
+	// PollInterval is the interval between the confirmation checks of the broadcast payloads
+	PollInterval time.Duration
+	// TicketTTL is how long the state of a confirmed or failed payload is kept, the ticket is unknown afterwards
+	TicketTTL time.Duration
+	// SeenTimeout is how long a broadcast payload waits for the indexer to return its reveal transaction before failing
+	SeenTimeout time.Duration
+	// Options are the inscribe options of every batch
+	Options InscribeOptions
+}
+
+// queueItem is a submitted payload and its state
+type queueItem struct {
+	state TicketState
+	data  []byte
+	done  chan struct{}
+	// revealTx is the reveal transaction once seen by the indexer, its inputs tell whether it was dropped
+	revealTx    *wire.MsgTx
+	broadcastAt time.Time
+	finishedAt  time.Time
+}
+
+// InscriptionQueue coalesces submitted payloads into batch inscriptions and tracks them until confirmed.
+// Batches are inscribed one at a time, so the payloads of the queue never compete for the same utxo
+type InscriptionQueue struct {
+	client     Clienter
+	cfg        QueueConfig
+	logger     log.Logger
+	lock       sync.Mutex
+	lastTicket Ticket
+	items      map[Ticket]*queueItem
+	pending    []*queueItem
+	broadcast  []*queueItem
+	submitted  chan struct{}
+	stop       chan struct{}
+	stopped    bool
+	wg         sync.WaitGroup
+}
+
+// NewInscriptionQueue creates an inscription queue of the client and starts its worker
+func NewInscriptionQueue(client Clienter, cfg QueueConfig, parentLogger log.Logger) *InscriptionQueue {
+	if cfg.MaxBatchSize <= 0 {
+		cfg.MaxBatchSize = defaultQueueMaxBatchSize
+	}
+	if cfg.MaxBatchBytes <= 0 {
+		cfg.MaxBatchBytes = defaultQueueMaxBatchBytes
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = defaultQueueMaxDelay
+	}
+	if cfg.Confirmations <= 0 {
+		cfg.Confirmations = defaultQueueConfirmations
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultQueuePollInterval
+	}
+	if cfg.TicketTTL <= 0 {
+		cfg.TicketTTL = defaultQueueTicketTTL
+	}
+	if cfg.SeenTimeout <= 0 {
+		cfg.SeenTimeout = defaultQueueSeenTimeout
+	}
+
+	queue := &InscriptionQueue{
+		client:    client,
+		cfg:       cfg,
+		logger:    parentLogger.New("module", common.QUEUE),
+		items:     make(map[Ticket]*queueItem),
+		submitted: make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	queue.wg.Add(1)
+	go queue.run()
+	return queue
+}
+
+// Submit adds a payload to the queue and returns its ticket
+func (q *InscriptionQueue) Submit(data []byte) (Ticket, error) {
+	if len(data) > q.cfg.MaxBatchBytes {
+		return 0, fmt.Errorf("payload size %d exceeds the batch limit of %d bytes", len(data), q.cfg.MaxBatchBytes)
+	}
+
+	q.lock.Lock()
+	if q.stopped {
+		q.lock.Unlock()
+		return 0, ErrQueueStopped
+	}
+	q.lastTicket++
+	item := &queueItem{
+		state: TicketState{Ticket: q.lastTicket, Status: TicketPending},
+		data:  data,
+		done:  make(chan struct{}),
+	}
+	q.items[item.state.Ticket] = item
+	q.pending = append(q.pending, item)
+	q.lock.Unlock()
+
+	select {
+	case q.submitted <- struct{}{}:
+	default:
+	}
+	return item.state.Ticket, nil
+}
+
+// Status returns the current state of a ticket, finished tickets are known for the ticket TTL
+func (q *InscriptionQueue) Status(ticket Ticket) (*TicketState, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	item, ok := q.items[ticket]
+	if !ok {
+		return nil, fmt.Errorf("unknown ticket %d", ticket)
+	}
+	state := item.state
+	return &state, nil
+}
+
+// Done returns a channel closed once the ticket is confirmed or failed
+func (q *InscriptionQueue) Done(ticket Ticket) (<-chan struct{}, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	item, ok := q.items[ticket]
+	if !ok {
+		return nil, fmt.Errorf("unknown ticket %d", ticket)
+	}
+	return item.done, nil
+}
+
+// Stop stops the worker, the pending payloads aren't inscribed and the broadcast payloads are no longer tracked.
+// Both are failed with ErrQueueStopped
+func (q *InscriptionQueue) Stop() {
+	q.lock.Lock()
+	if q.stopped {
+		q.lock.Unlock()
+		return
+	}
+	q.stopped = true
+	q.lock.Unlock()
+
+	close(q.stop)
+	q.wg.Wait()
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, item := range append(q.pending, q.broadcast...) {
+		q.finish(item, TicketFailed, ErrQueueStopped)
+	}
+	q.pending, q.broadcast = nil, nil
+}
+
+// run is the worker loop, it inscribes a batch once it's full or its oldest payload waited MaxDelay
+func (q *InscriptionQueue) run() {
+	defer q.wg.Done()
+
+	pollTicker := time.NewTicker(q.cfg.PollInterval)
+	defer pollTicker.Stop()
+	// the flush timer starts with the first payload of a batch
+	var flushTimer *time.Timer
+	var flushChannel <-chan time.Time
+	stopFlushTimer := func() {
+		if flushTimer != nil {
+			flushTimer.Stop()
+			flushTimer, flushChannel = nil, nil
+		}
+	}
+	defer stopFlushTimer()
+
+	for {
+		select {
+		case <-q.stop:
+			return
+		case <-q.submitted:
+			for q.batchReady() {
+				stopFlushTimer()
+				q.flush()
+			}
+		case <-flushChannel:
+			stopFlushTimer()
+			q.flush()
+		case <-pollTicker.C:
+			q.pollConfirmations()
+			q.evictFinished()
+		}
+
+		if flushTimer == nil && q.hasPending() {
+			flushTimer = time.NewTimer(q.cfg.MaxDelay)
+			flushChannel = flushTimer.C
+		}
+	}
+}
+
+// hasPending returns true if there are payloads waiting for a batch
+func (q *InscriptionQueue) hasPending() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.pending) > 0
+}
+
+// batchReady returns true if the pending payloads fill up a batch
+func (q *InscriptionQueue) batchReady() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.pending) >= q.cfg.MaxBatchSize {
+		return true
+	}
+	size := 0
+	for _, item := range q.pending {
+		size += len(item.data)
+	}
+	return size >= q.cfg.MaxBatchBytes
+}
+
+// flush inscribes the oldest pending payloads within the batch limits
+func (q *InscriptionQueue) flush() {
+	q.lock.Lock()
+	batch := []*queueItem{}
+	size := 0
+	for _, item := range q.pending {
+		if len(batch) == q.cfg.MaxBatchSize || (len(batch) > 0 && size+len(item.data) > q.cfg.MaxBatchBytes) {
+			break
+		}
+		batch = append(batch, item)
+		size += len(item.data)
+	}
+	q.pending = q.pending[len(batch):]
+	q.lock.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	payloads := make([][]byte, len(batch))
+	for i, item := range batch {
+		payloads[i] = item.data
+	}
+	result, err := q.client.InscribeBatch(payloads, q.cfg.Options)
+	if err != nil {
+		q.logger.Error("Failed to inscribe batch", "payloads", len(batch), "err", err)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for i, item := range batch {
+		if result == nil {
+			q.finish(item, TicketFailed, err)
+			continue
+		}
+		item.state.CommitTxHash = result.CommitTxHash
+		if itemErr := result.Items[i].Err; itemErr != nil {
+			q.finish(item, TicketFailed, itemErr)
+			continue
+		}
+		item.state.Status = TicketBroadcast
+		item.state.RevealTxHash = result.Items[i].RevealTxHash
+		item.state.InscriptionID = result.Items[i].InscriptionID
+		item.broadcastAt = time.Now()
+		q.broadcast = append(q.broadcast, item)
+	}
+}
+
+// pollConfirmations updates the confirmations of the broadcast payloads and fails the payloads
+// whose reveal transaction was dropped, double spent or never seen by the indexer
+func (q *InscriptionQueue) pollConfirmations() {
+	q.lock.Lock()
+	broadcast := make([]*queueItem, len(q.broadcast))
+	copy(broadcast, q.broadcast)
+	q.lock.Unlock()
+
+	// reveals shared by several payloads of a single reveal batch are fetched once
+	polled := make(map[chainhash.Hash]bool)
+	confirmations := make(map[chainhash.Hash]int64)
+	revealTxs := make(map[chainhash.Hash]*wire.MsgTx)
+	conflicts := make(map[chainhash.Hash]error)
+	for _, item := range broadcast {
+		revealTxHash := *item.state.RevealTxHash
+		if polled[revealTxHash] {
+			continue
+		}
+		polled[revealTxHash] = true
+
+		tx, err := q.client.GetTransaction(revealTxHash.String(), true)
+		if err != nil {
+			q.logger.Warn("Failed to get reveal transaction", "txHash", revealTxHash, "err", err)
+			// a reveal transaction seen before is no longer known if it was dropped or replaced
+			if item.revealTx != nil {
+				if err := q.client.CheckConflicts(item.revealTx); err != nil {
+					conflicts[revealTxHash] = err
+				}
+			}
+			continue
+		}
+		confirmations[revealTxHash] = int64(tx.Confirmations)
+		if item.revealTx == nil {
+			if revealTx, err := deserializeTransaction(tx.Hex); err == nil {
+				revealTxs[revealTxHash] = revealTx
+			}
+		}
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	remaining := []*queueItem{}
+	for _, item := range q.broadcast {
+		if err, ok := conflicts[*item.state.RevealTxHash]; ok {
+			q.logger.Error("Reveal transaction failed", "txHash", item.state.RevealTxHash, "err", err)
+			q.finish(item, TicketFailed, err)
+			continue
+		}
+		if revealTx, ok := revealTxs[*item.state.RevealTxHash]; ok {
+			item.revealTx = revealTx
+		}
+		txConfirmations, seen := confirmations[*item.state.RevealTxHash]
+		if seen {
+			item.state.Confirmations = txConfirmations
+		} else if item.revealTx == nil && time.Since(item.broadcastAt) >= q.cfg.SeenTimeout {
+			q.logger.Error("Reveal transaction not seen", "txHash", item.state.RevealTxHash)
+			q.finish(item, TicketFailed, fmt.Errorf("%w: %s", ErrRevealNotSeen, item.state.RevealTxHash))
+			continue
+		}
+		if item.state.Confirmations >= q.cfg.Confirmations {
+			q.finish(item, TicketConfirmed, nil)
+			continue
+		}
+		remaining = append(remaining, item)
+	}
+	q.broadcast = remaining
+}
+
+// finish sets the final status of a payload and closes its done channel, the lock must be held
+func (q *InscriptionQueue) finish(item *queueItem, status TicketStatus, err error) {
+	item.state.Status = status
+	item.state.Err = err
+	item.data = nil
+	item.revealTx = nil
+	item.finishedAt = time.Now()
+	close(item.done)
+}
+
+// evictFinished forgets the payloads finished for longer than the ticket TTL
+func (q *InscriptionQueue) evictFinished() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for ticket, item := range q.items {
+		if !item.finishedAt.IsZero() && time.Since(item.finishedAt) >= q.cfg.TicketTTL {
+			delete(q.items, ticket)
+		}
+	}
+}
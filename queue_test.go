@@ -0,0 +1,214 @@
+package btcman
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func waitTicket(t *testing.T, queue *InscriptionQueue, ticket Ticket) *TicketState {
+	done, err := queue.Done(ticket)
+	require.NoError(t, err)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ticket %d not done", ticket)
+	}
+	state, err := queue.Status(ticket)
+	require.NoError(t, err)
+	return state
+}
+
+func TestInscriptionQueue(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 1_000_000, 1_000_000)
+	commitTxs := 0
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return(func(tx *wire.MsgTx) string {
+		if len(ParseInscriptions(tx)) == 0 {
+			commitTxs++
+		}
+		return tx.TxHash().String()
+	}, nil)
+	mockIndexer.On("GetTransaction", mock.Anything, mock.Anything, true).Return(&btcjson.TxRawResult{Confirmations: 3}, nil)
+
+	queue := NewInscriptionQueue(btcman, QueueConfig{
+		MaxBatchSize:  2,
+		MaxDelay:      50 * time.Millisecond,
+		Confirmations: 3,
+		PollInterval:  10 * time.Millisecond,
+	}, log.New("testing"))
+	defer queue.Stop()
+
+	tickets := []Ticket{}
+	for _, payload := range []string{"batch 1", "batch 2", "batch 3"} {
+		ticket, err := queue.Submit([]byte(payload))
+		require.NoError(t, err)
+		tickets = append(tickets, ticket)
+	}
+
+	states := []*TicketState{}
+	for _, ticket := range tickets {
+		state := waitTicket(t, queue, ticket)
+		assert.Equal(t, TicketConfirmed, state.Status)
+		assert.NoError(t, state.Err)
+		assert.NotEmpty(t, state.InscriptionID)
+		assert.EqualValues(t, 3, state.Confirmations)
+		states = append(states, state)
+	}
+	// the first two payloads fill a batch, the third is flushed after the max delay
+	assert.Equal(t, states[0].CommitTxHash, states[1].CommitTxHash)
+	assert.Equal(t, 2, commitTxs)
+
+	_, err := queue.Submit(make([]byte, DefaultChunkSize+1))
+	assert.Error(t, err)
+	_, err = queue.Status(100)
+	assert.Error(t, err)
+}
+
+func TestInscriptionQueueFailure(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return("", errors.New("rejected"))
+
+	queue := NewInscriptionQueue(btcman, QueueConfig{MaxDelay: 10 * time.Millisecond}, log.New("testing"))
+	ticket, err := queue.Submit([]byte("batch"))
+	require.NoError(t, err)
+
+	state := waitTicket(t, queue, ticket)
+	assert.Equal(t, TicketFailed, state.Status)
+	assert.Error(t, state.Err)
+
+	queue.Stop()
+	_, err = queue.Submit([]byte("batch"))
+	assert.ErrorIs(t, err, ErrQueueStopped)
+}
+
+// conflictTestClient is a client whose reveal transactions disappear from the indexer after the first poll
+// because they were double spent
+type conflictTestClient struct {
+	Clienter
+	lock   sync.Mutex
+	sent   map[string]*wire.MsgTx
+	polled map[string]bool
+}
+
+func (c *conflictTestClient) GetTransaction(txid string, verbose bool) (*btcjson.TxRawResult, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tx, ok := c.sent[txid]
+	if !ok || c.polled[txid] {
+		return nil, errors.New("not found")
+	}
+	c.polled[txid] = true
+	txHex, err := indexer.GetTxHex(tx)
+	if err != nil {
+		return nil, err
+	}
+	return &btcjson.TxRawResult{Hex: txHex}, nil
+}
+
+func (c *conflictTestClient) CheckConflicts(tx *wire.MsgTx) error {
+	return fmt.Errorf("%w: %s", ErrTxDoubleSpent, tx.TxHash())
+}
+
+func TestInscriptionQueueDoubleSpentReveal(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	client := &conflictTestClient{
+		Clienter: newTestWriterClient(t, mockIndexer, 1_000_000),
+		sent:     make(map[string]*wire.MsgTx),
+		polled:   make(map[string]bool),
+	}
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return(func(tx *wire.MsgTx) string {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		client.sent[tx.TxHash().String()] = tx
+		return tx.TxHash().String()
+	}, nil)
+
+	queue := NewInscriptionQueue(client, QueueConfig{
+		MaxDelay:     10 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+		TicketTTL:    50 * time.Millisecond,
+	}, log.New("testing"))
+	defer queue.Stop()
+	ticket, err := queue.Submit([]byte("batch"))
+	require.NoError(t, err)
+
+	state := waitTicket(t, queue, ticket)
+	assert.Equal(t, TicketFailed, state.Status)
+	assert.ErrorIs(t, state.Err, ErrTxDoubleSpent)
+	assert.NotNil(t, state.RevealTxHash)
+
+	// the finished ticket is forgotten after its TTL
+	assert.Eventually(t, func() bool {
+		_, err := queue.Status(ticket)
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestInscriptionQueueStop(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return(func(tx *wire.MsgTx) string {
+		return tx.TxHash().String()
+	}, nil)
+	mockIndexer.On("GetTransaction", mock.Anything, mock.Anything, true).Return(&btcjson.TxRawResult{}, nil)
+
+	queue := NewInscriptionQueue(btcman, QueueConfig{
+		MaxBatchSize: 2,
+		PollInterval: 10 * time.Millisecond,
+	}, log.New("testing"))
+
+	// the first two payloads are broadcast and wait for confirmations, the third waits for a batch
+	tickets := []Ticket{}
+	for _, payload := range []string{"batch 1", "batch 2", "batch 3"} {
+		ticket, err := queue.Submit([]byte(payload))
+		require.NoError(t, err)
+		tickets = append(tickets, ticket)
+	}
+	assert.Eventually(t, func() bool {
+		state, err := queue.Status(tickets[1])
+		return err == nil && state.Status == TicketBroadcast
+	}, 5*time.Second, 10*time.Millisecond)
+
+	queue.Stop()
+	for _, ticket := range tickets {
+		state := waitTicket(t, queue, ticket)
+		assert.Equal(t, TicketFailed, state.Status)
+		assert.ErrorIs(t, state.Err, ErrQueueStopped)
+	}
+}
+
+func TestInscriptionQueueRevealNotSeen(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return(func(tx *wire.MsgTx) string {
+		return tx.TxHash().String()
+	}, nil)
+	// the indexer never returns the reveal transaction
+	mockIndexer.On("GetTransaction", mock.Anything, mock.Anything, true).Return((*btcjson.TxRawResult)(nil), errors.New("not found"))
+
+	queue := NewInscriptionQueue(btcman, QueueConfig{
+		MaxDelay:     10 * time.Millisecond,
+		PollInterval: 10 * time.Millisecond,
+		SeenTimeout:  50 * time.Millisecond,
+	}, log.New("testing"))
+	defer queue.Stop()
+	ticket, err := queue.Submit([]byte("batch"))
+	require.NoError(t, err)
+
+	state := waitTicket(t, queue, ticket)
+	assert.Equal(t, TicketFailed, state.Status)
+	assert.ErrorIs(t, state.Err, ErrRevealNotSeen)
+}
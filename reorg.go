@@ -0,0 +1,277 @@
+package btcman
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/common"
+	"github.com/ledgerwatch/log/v3"
+)
+
+const (
+	defaultReorgTrackerDepth = 100
+	reorgEventsBuffer        = 16
+)
+
+// ErrReorgTooDeep is returned when none of the tracked headers is part of the best chain anymore
+var ErrReorgTooDeep = errors.New("reorg is deeper than the tracked headers")
+
+// OrphanedBlock is a block replaced by a reorg
+type OrphanedBlock struct {
+	Height int32
+	Hash   chainhash.Hash
+}
+
+// ReorgEvent is the rollback of the blocks above the fork point
+type ReorgEvent struct {
+	// ForkHeight is the height of the last block common to the replaced and the new chain
+	ForkHeight int32
+	ForkHash   chainhash.Hash
+	// OrphanedBlocks are the replaced blocks, by ascending height
+	OrphanedBlocks []OrphanedBlock
+	// Transactions are the tracked transactions of the orphaned blocks
+	Transactions []chainhash.Hash
+	// Inscriptions are the IDs of the tracked inscriptions of the orphaned blocks
+	Inscriptions []string
+}
+
+// trackedHeader is a header of the best chain known to the tracker
+type trackedHeader struct {
+	height int32
+	hash   chainhash.Hash
+	header wire.BlockHeader
+}
+
+// ReorgTracker keeps the recent headers of the best chain and detects the tips that don't extend them.
+// The transactions and inscriptions tracked in the replaced blocks are listed by the rollback events
+type ReorgTracker struct {
+	client       Clienter
+	depth        int
+	logger       log.Logger
+	lock         sync.Mutex
+	headers      []trackedHeader
+	transactions map[int32][]chainhash.Hash
+	inscriptions map[int32][]string
+	events       chan ReorgEvent
+	stop         chan struct{}
+	wg           sync.WaitGroup
+}
+
+// NewReorgTracker creates a tracker keeping depth headers, a default depth is used if 0
+func NewReorgTracker(client Clienter, depth int, parentLogger log.Logger) *ReorgTracker {
+	if depth <= 0 {
+		depth = defaultReorgTrackerDepth
+	}
+	return &ReorgTracker{
+		client:       client,
+		depth:        depth,
+		logger:       parentLogger.New("module", common.REORG),
+		transactions: make(map[int32][]chainhash.Hash),
+		inscriptions: make(map[int32][]string),
+	}
+}
+
+// TrackTransaction adds a transaction confirmed at height to the rollback events
+func (t *ReorgTracker) TrackTransaction(height int32, txHash chainhash.Hash) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.transactions[height] = append(t.transactions[height], txHash)
+}
+
+// TrackInscription adds a scanned inscription and its reveal transaction to the rollback events
+func (t *ReorgTracker) TrackInscription(inscription *ScannedInscription) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.inscriptions[inscription.Height] = append(t.inscriptions[inscription.Height], inscription.InscriptionID)
+	for _, txHash := range t.transactions[inscription.Height] {
+		if txHash == inscription.TxHash {
+			return
+		}
+	}
+	t.transactions[inscription.Height] = append(t.transactions[inscription.Height], inscription.TxHash)
+}
+
+// Tip returns the height and hash of the last tracked header, false if no header is tracked yet
+func (t *ReorgTracker) Tip() (int32, chainhash.Hash, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.headers) == 0 {
+		return 0, chainhash.Hash{}, false
+	}
+	tip := t.headers[len(t.headers)-1]
+	return tip.height, tip.hash, true
+}
+
+// Update compares the tracked headers with the best chain and extends them up to its tip.
+// Returns the rollback event if the tracked headers were replaced by a reorg, nil otherwise
+func (t *ReorgTracker) Update() (*ReorgEvent, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	tipHeight, err := t.client.GetBlockchainHeight()
+	if err != nil {
+		return nil, err
+	}
+	if len(t.headers) == 0 {
+		// the first update tracks the last depth headers
+		height := tipHeight - int32(t.depth) + 1
+		if height < 0 {
+			height = 0
+		}
+		header, err := t.client.GetBlockHeader(uint64(height))
+		if err != nil {
+			return nil, err
+		}
+		t.headers = append(t.headers, trackedHeader{height: height, hash: header.BlockHash(), header: *header})
+		return nil, t.extend(tipHeight)
+	}
+
+	forkIndex, err := t.forkIndex(tipHeight)
+	if err != nil {
+		return nil, err
+	}
+	var event *ReorgEvent
+	if forkIndex < len(t.headers)-1 {
+		event = t.rollback(forkIndex)
+		t.logger.Warn("Reorg detected", "forkHeight", event.ForkHeight, "orphanedBlocks", len(event.OrphanedBlocks),
+			"transactions", len(event.Transactions), "inscriptions", len(event.Inscriptions))
+	}
+
+	if err := t.extend(tipHeight); err != nil {
+		return event, err
+	}
+	return event, nil
+}
+
+// forkIndex returns the index of the last tracked header that is still part of the best chain
+func (t *ReorgTracker) forkIndex(tipHeight int32) (int, error) {
+	for i := len(t.headers) - 1; i >= 0; i-- {
+		if t.headers[i].height > tipHeight {
+			continue
+		}
+		header, err := t.client.GetBlockHeader(uint64(t.headers[i].height))
+		if err != nil {
+			return 0, err
+		}
+		if header.BlockHash() == t.headers[i].hash {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %d headers from height %d", ErrReorgTooDeep, len(t.headers), t.headers[0].height)
+}
+
+// rollback drops the headers above the fork index and returns the event listing what they contained
+func (t *ReorgTracker) rollback(forkIndex int) *ReorgEvent {
+	fork := t.headers[forkIndex]
+	event := &ReorgEvent{ForkHeight: fork.height, ForkHash: fork.hash}
+	for _, orphaned := range t.headers[forkIndex+1:] {
+		event.OrphanedBlocks = append(event.OrphanedBlocks, OrphanedBlock{Height: orphaned.height, Hash: orphaned.hash})
+	}
+	// transactions may be tracked above the tracked headers, e.g. found by a scan before an update
+	heights := []int32{}
+	for height := range t.transactions {
+		if height > fork.height {
+			heights = append(heights, height)
+		}
+	}
+	for height := range t.inscriptions {
+		if _, ok := t.transactions[height]; !ok && height > fork.height {
+			heights = append(heights, height)
+		}
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	for _, height := range heights {
+		event.Transactions = append(event.Transactions, t.transactions[height]...)
+		event.Inscriptions = append(event.Inscriptions, t.inscriptions[height]...)
+		delete(t.transactions, height)
+		delete(t.inscriptions, height)
+	}
+	t.headers = t.headers[:forkIndex+1]
+	return event
+}
+
+// extend appends the headers up to the tip, each header must link to the previous one.
+// A header not linking means a reorg happened meanwhile, it's detected by the next update
+func (t *ReorgTracker) extend(tipHeight int32) error {
+	for {
+		last := t.headers[len(t.headers)-1]
+		if last.height >= tipHeight {
+			break
+		}
+		header, err := t.client.GetBlockHeader(uint64(last.height + 1))
+		if err != nil {
+			return err
+		}
+		if header.PrevBlock != last.hash {
+			t.logger.Warn("Header doesn't extend the tracked chain", "height", last.height+1)
+			break
+		}
+		t.headers = append(t.headers, trackedHeader{height: last.height + 1, hash: header.BlockHash(), header: *header})
+	}
+
+	if len(t.headers) > t.depth {
+		t.headers = t.headers[len(t.headers)-t.depth:]
+	}
+	// the tracked items below the headers can't be rolled back anymore
+	for height := range t.transactions {
+		if height < t.headers[0].height {
+			delete(t.transactions, height)
+		}
+	}
+	for height := range t.inscriptions {
+		if height < t.headers[0].height {
+			delete(t.inscriptions, height)
+		}
+	}
+	return nil
+}
+
+// Start polls the best chain every interval and sends the rollback events to the returned channel,
+// which is closed by Stop
+func (t *ReorgTracker) Start(interval time.Duration) <-chan ReorgEvent {
+	t.events = make(chan ReorgEvent, reorgEventsBuffer)
+	t.stop = make(chan struct{})
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer close(t.events)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			event, err := t.Update()
+			if err != nil {
+				t.logger.Error("Failed to update the tracked headers", "err", err)
+			}
+			if event != nil {
+				select {
+				case t.events <- *event:
+				case <-t.stop:
+					return
+				}
+			}
+
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return t.events
+}
+
+// Stop stops the polling started by Start
+func (t *ReorgTracker) Stop() {
+	if t.stop == nil {
+		return
+	}
+	close(t.stop)
+	t.wg.Wait()
+	t.stop = nil
+}
@@ -0,0 +1,133 @@
+package btcman
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chainTestClient serves the headers of a chain of linked blocks
+type chainTestClient struct {
+	Clienter
+	headers []wire.BlockHeader
+}
+
+// mine appends blocks up to height, fork distinguishes the blocks of competing chains
+func (c *chainTestClient) mine(height int32, fork uint32) {
+	for int32(len(c.headers)) <= height {
+		header := wire.BlockHeader{Nonce: uint32(len(c.headers)), Bits: fork}
+		if len(c.headers) > 0 {
+			header.PrevBlock = c.headers[len(c.headers)-1].BlockHash()
+		}
+		c.headers = append(c.headers, header)
+	}
+}
+
+// reorg replaces the blocks from height with the blocks of another fork up to tipHeight
+func (c *chainTestClient) reorg(height, tipHeight int32, fork uint32) {
+	c.headers = c.headers[:height]
+	c.mine(tipHeight, fork)
+}
+
+func (c *chainTestClient) GetBlockchainHeight() (int32, error) {
+	return int32(len(c.headers)) - 1, nil
+}
+
+func (c *chainTestClient) GetBlockHeader(height uint64) (*wire.BlockHeader, error) {
+	header := c.headers[height]
+	return &header, nil
+}
+
+func TestReorgTracker(t *testing.T) {
+	client := &chainTestClient{}
+	client.mine(20, 0)
+	tracker := NewReorgTracker(client, 10, log.New("testing"))
+
+	event, err := tracker.Update()
+	require.NoError(t, err)
+	assert.Nil(t, event)
+	height, hash, ok := tracker.Tip()
+	require.True(t, ok)
+	assert.EqualValues(t, 20, height)
+	assert.Equal(t, client.headers[20].BlockHash(), hash)
+
+	inscription := &ScannedInscription{InscriptionID: "inscription", TxHash: chainhash.HashH([]byte("reveal")), Height: 19}
+	tracker.TrackInscription(inscription)
+	tracker.TrackTransaction(17, chainhash.HashH([]byte("transaction")))
+	tracker.TrackTransaction(15, chainhash.HashH([]byte("kept")))
+
+	client.mine(22, 0)
+	event, err = tracker.Update()
+	require.NoError(t, err)
+	assert.Nil(t, event)
+
+	forkHash := client.headers[16].BlockHash()
+	client.reorg(17, 23, 1)
+	event, err = tracker.Update()
+	require.NoError(t, err)
+	require.NotNil(t, event)
+	assert.EqualValues(t, 16, event.ForkHeight)
+	assert.Equal(t, forkHash, event.ForkHash)
+	require.Len(t, event.OrphanedBlocks, 6)
+	assert.EqualValues(t, 17, event.OrphanedBlocks[0].Height)
+	assert.Equal(t, []chainhash.Hash{chainhash.HashH([]byte("transaction")), inscription.TxHash}, event.Transactions)
+	assert.Equal(t, []string{"inscription"}, event.Inscriptions)
+
+	height, hash, _ = tracker.Tip()
+	assert.EqualValues(t, 23, height)
+	assert.Equal(t, client.headers[23].BlockHash(), hash)
+
+	// the chain is replaced below the tracked headers
+	client.reorg(5, 30, 2)
+	_, err = tracker.Update()
+	assert.ErrorIs(t, err, ErrReorgTooDeep)
+}
+
+func TestReorgTrackerEvents(t *testing.T) {
+	client := &chainTestClient{}
+	client.mine(10, 0)
+	tracker := NewReorgTracker(client, 0, log.New("testing"))
+	_, err := tracker.Update()
+	require.NoError(t, err)
+
+	client.reorg(9, 11, 1)
+	events := tracker.Start(10 * time.Millisecond)
+	select {
+	case event := <-events:
+		assert.EqualValues(t, 8, event.ForkHeight)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reorg event")
+	}
+	tracker.Stop()
+	_, open := <-events
+	assert.False(t, open)
+}
+
+func TestInscriptionSyncRollback(t *testing.T) {
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	sync := NewInscriptionSync(&syncTestClient{}, store, SyncConfig{}, log.New("testing"))
+	forkHash := chainhash.HashH([]byte("fork"))
+
+	require.NoError(t, sync.Rollback(&ReorgEvent{ForkHeight: 10, ForkHash: forkHash}))
+	checkpoint, err := store.Load()
+	require.NoError(t, err)
+	assert.Nil(t, checkpoint)
+
+	require.NoError(t, store.Save(Checkpoint{Height: 12}))
+	require.NoError(t, sync.Rollback(&ReorgEvent{ForkHeight: 10, ForkHash: forkHash}))
+	checkpoint, err = store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, &Checkpoint{Height: 10, BlockHash: forkHash}, checkpoint)
+
+	// a checkpoint below the fork point is kept
+	require.NoError(t, sync.Rollback(&ReorgEvent{ForkHeight: 11}))
+	checkpoint, err = store.Load()
+	require.NoError(t, err)
+	assert.EqualValues(t, 10, checkpoint.Height)
+}
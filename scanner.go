@@ -0,0 +1,259 @@
+package btcman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+)
+
+// ScannedInscription is an inscription of the publisher found by the scanner
+type ScannedInscription struct {
+	*Inscription
+	// InscriptionID is the ordinals ID of the inscription
+	InscriptionID string
+	// TxHash is the hash of the reveal transaction
+	TxHash chainhash.Hash
+	// Height is the block height of the reveal transaction
+	Height int32
+	// Position is the index of the reveal transaction in its block, as in the merkle proof of the transaction
+	Position int
+}
+
+// commitLookback is the number of blocks before the start height whose commit transactions are scanned
+// for reveals, a commit is usually revealed in the same or the next block
+const commitLookback = 6
+
+// revealCandidate is a reveal transaction found by the scanner with its position in the block
+type revealCandidate struct {
+	tx       *wire.MsgTx
+	height   int32
+	position int
+}
+
+// inscriptionScanner walks the history of the publisher scripts, transactions are cached for the scan
+type inscriptionScanner struct {
+	client  *Client
+	scripts [][]byte
+	history map[string]bool
+	txCache map[string]*wire.MsgTx
+}
+
+// ScanInscriptions walks the confirmed history of the publisher scripts from startHeight and calls handle with
+// every inscription revealed by a commit transaction of the publisher, ordered by block height and position in the block.
+// Reveals are found through their commit transaction or through their output paying the publisher, so a reveal
+// not paying the publisher is found if its commit is at most commitLookback blocks before startHeight.
+// An error of handle stops the scan and is returned
+func (client *Client) ScanInscriptions(startHeight int32, handle func(*ScannedInscription) error) error {
+	scanner := &inscriptionScanner{
+		client:  client,
+		scripts: client.keychain.GetPkScripts(),
+		history: make(map[string]bool),
+		txCache: make(map[string]*wire.MsgTx),
+	}
+
+	entries, err := scanner.confirmedHistory(startHeight - commitLookback)
+	if err != nil {
+		return err
+	}
+	candidates, err := scanner.reveals(entries)
+	if err != nil {
+		return err
+	}
+
+	// the candidates found by the commits of the lookback may be revealed before the start height
+	inRange := []*revealCandidate{}
+	for _, candidate := range candidates {
+		if candidate.height >= startHeight {
+			inRange = append(inRange, candidate)
+		}
+	}
+	candidates = inRange
+	if err := scanner.sortByPosition(candidates); err != nil {
+		return err
+	}
+
+	for _, candidate := range candidates {
+		txHash := candidate.tx.TxHash()
+		for _, inscription := range ParseInscriptions(candidate.tx) {
+			scanned := &ScannedInscription{
+				Inscription:   inscription,
+				InscriptionID: InscriptionID{TxHash: txHash, Index: uint32(inscription.Index)}.String(),
+				TxHash:        txHash,
+				Height:        candidate.height,
+				Position:      candidate.position,
+			}
+			if err := handle(scanned); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// confirmedHistory returns the confirmed transactions of the publisher scripts from startHeight, ordered by height.
+// The order of the indexer is kept within a block
+func (s *inscriptionScanner) confirmedHistory(startHeight int32) ([]*indexer.Transaction, error) {
+	entries := []*indexer.Transaction{}
+	for _, pkScript := range s.scripts {
+		history, err := s.client.IndexerClient.GetHistory(context.Background(), pkScript)
+		if err != nil {
+			return nil, err
+		}
+		for _, transaction := range history {
+			if s.history[transaction.TxHash] {
+				continue
+			}
+			s.history[transaction.TxHash] = true
+			if transaction.Height > 0 && transaction.Height >= startHeight {
+				entries = append(entries, transaction)
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Height < entries[j].Height
+	})
+	return entries, nil
+}
+
+// reveals returns the confirmed reveal transactions of the publisher commits found from the history entries
+func (s *inscriptionScanner) reveals(entries []*indexer.Transaction) ([]*revealCandidate, error) {
+	found := make(map[chainhash.Hash]*revealCandidate)
+	add := func(candidate *revealCandidate) {
+		if _, ok := found[candidate.tx.TxHash()]; !ok {
+			found[candidate.tx.TxHash()] = candidate
+		}
+	}
+
+	for _, entry := range entries {
+		tx, err := s.tx(entry.TxHash)
+		if err != nil {
+			return nil, err
+		}
+
+		isPublisherTx, err := s.spendsPublisher(tx)
+		if err != nil {
+			return nil, err
+		}
+		if isPublisherTx {
+			// the taproot outputs not paying the publisher are commit outputs, the transactions spending them are reveals
+			for outputIndex, txOut := range tx.TxOut {
+				if !txscript.IsPayToTaproot(txOut.PkScript) || s.isPublisherScript(txOut.PkScript) {
+					continue
+				}
+				outPoint := wire.OutPoint{Hash: tx.TxHash(), Index: uint32(outputIndex)}
+				revealTx, height, err := s.client.findSpendingTx(outPoint, txOut.PkScript)
+				if errors.Is(err, errOutputNotSpent) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				// an unconfirmed reveal is found by a later scan
+				if height <= 0 || len(ParseInscriptions(revealTx)) == 0 {
+					continue
+				}
+				add(&revealCandidate{tx: revealTx, height: height})
+			}
+			continue
+		}
+
+		// a reveal paying the publisher whose commit was sent before the start height
+		inscriptions := ParseInscriptions(tx)
+		if len(inscriptions) == 0 {
+			continue
+		}
+		commitHash := tx.TxIn[inscriptions[0].InputIndex].PreviousOutPoint.Hash.String()
+		if !s.history[commitHash] {
+			continue
+		}
+		commitTx, err := s.tx(commitHash)
+		if err != nil {
+			return nil, err
+		}
+		isPublisherCommit, err := s.spendsPublisher(commitTx)
+		if err != nil {
+			return nil, err
+		}
+		if isPublisherCommit {
+			add(&revealCandidate{tx: tx, height: entry.Height})
+		}
+	}
+
+	candidates := make([]*revealCandidate, 0, len(found))
+	for _, candidate := range found {
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// sortByPosition sorts the candidates by height and by their position in the block, given by their merkle branch
+func (s *inscriptionScanner) sortByPosition(candidates []*revealCandidate) error {
+	for _, candidate := range candidates {
+		txHash := candidate.tx.TxHash().String()
+		merkle, err := s.client.IndexerClient.GetMerkle(context.Background(), txHash, candidate.height)
+		if err != nil {
+			return err
+		}
+		if merkle.BlockHeight != candidate.height {
+			return fmt.Errorf("transaction %s is at height %d, not %d", txHash, merkle.BlockHeight, candidate.height)
+		}
+		candidate.position = merkle.Pos
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].height != candidates[j].height {
+			return candidates[i].height < candidates[j].height
+		}
+		return candidates[i].position < candidates[j].position
+	})
+	return nil
+}
+
+// spendsPublisher returns true if the transaction spends an output of the publisher scripts.
+// Such an output is created by a transaction of the publisher history, other inputs are not fetched
+func (s *inscriptionScanner) spendsPublisher(tx *wire.MsgTx) (bool, error) {
+	for _, txIn := range tx.TxIn {
+		prevHash := txIn.PreviousOutPoint.Hash.String()
+		if !s.history[prevHash] {
+			continue
+		}
+		prevTx, err := s.tx(prevHash)
+		if err != nil {
+			return false, err
+		}
+		if int(txIn.PreviousOutPoint.Index) < len(prevTx.TxOut) &&
+			s.isPublisherScript(prevTx.TxOut[txIn.PreviousOutPoint.Index].PkScript) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isPublisherScript returns true if pkScript is one of the publisher scripts
+func (s *inscriptionScanner) isPublisherScript(pkScript []byte) bool {
+	for _, script := range s.scripts {
+		if bytes.Equal(script, pkScript) {
+			return true
+		}
+	}
+	return false
+}
+
+// tx returns a decoded transaction, fetched once per scan
+func (s *inscriptionScanner) tx(txHash string) (*wire.MsgTx, error) {
+	if tx, ok := s.txCache[txHash]; ok {
+		return tx, nil
+	}
+	tx, err := s.client.getMsgTx(txHash)
+	if err != nil {
+		return nil, err
+	}
+	s.txCache[txHash] = tx
+	return tx, nil
+}
@@ -0,0 +1,154 @@
+package btcman
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestInscription returns the commit and reveal transactions of a dry run inscription,
+// the utxo of the commit transaction stays leased
+func newTestInscription(t *testing.T, btcman *Client, data []byte, opts InscribeOptions) (*wire.MsgTx, *wire.MsgTx) {
+	opts.DryRun = true
+	result, err := btcman.InscribeWithOptions(data, opts)
+	require.NoError(t, err)
+	commitTx, err := deserializeTransaction(result.CommitTxHex)
+	require.NoError(t, err)
+	revealTx, err := deserializeTransaction(result.RevealTxHexList[0])
+	require.NoError(t, err)
+	btcman.utxoManager.spend(commitTx, nil)
+	return commitTx, revealTx
+}
+
+func TestScanInscriptions(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 1_000_000, 1_000_000, 1_000_000)
+	pkScript := btcman.keychain.GetPkScript()
+
+	history := []*indexer.Transaction{}
+	for i := 0; i < 3; i++ {
+		// the funding transactions are served under the hashes listed by the test client
+		fundingTx := wire.NewMsgTx(wire.TxVersion)
+		fundingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(i)}, nil, nil))
+		fundingTx.AddTxOut(wire.NewTxOut(1_000_000, pkScript))
+		txHex, err := indexer.GetTxHex(fundingTx)
+		require.NoError(t, err)
+		fundingHash := chainhash.HashH([]byte(fmt.Sprintf("funding %d", i)))
+		mockIndexer.On("GetTransaction", mock.Anything, fundingHash.String(), false).Return(&btcjson.TxRawResult{Hex: txHex}, nil)
+		history = append(history, &indexer.Transaction{TxHash: fundingHash.String(), Height: 1})
+	}
+
+	holdingKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	holdingAddress, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(holdingKey.PubKey()), &chaincfg.RegressionNetParams)
+	require.NoError(t, err)
+
+	// the first reveal pays the publisher, the second one the holding address in a later block
+	commitTx1, revealTx1 := newTestInscription(t, btcman, []byte("first"), InscribeOptions{})
+	commitTx2, revealTx2 := newTestInscription(t, btcman, []byte("second"), InscribeOptions{Destination: holdingAddress.String()})
+	// the third reveal is listed after the first one by the history but comes before it in the block
+	commitTx3, revealTx3 := newTestInscription(t, btcman, []byte("third"), InscribeOptions{})
+
+	// a reveal paying the publisher from a commit of someone else
+	spoofData, err := InscribeOptions{}.inscriptionData([]byte("spoof"), "")
+	require.NoError(t, err)
+	spoofCommitTx, spoofRevealTxs := newChunkedTestTxs(t, []InscriptionData{spoofData})
+	spoofRevealTx := spoofRevealTxs[0]
+	spoofRevealTx.TxOut[0].PkScript = pkScript
+
+	for _, tx := range []*wire.MsgTx{commitTx1, revealTx1, commitTx2, revealTx2, commitTx3, revealTx3, spoofCommitTx, spoofRevealTx} {
+		mockTransaction(t, mockIndexer, tx)
+	}
+	history = append(history,
+		&indexer.Transaction{TxHash: commitTx1.TxHash().String(), Height: 1001},
+		&indexer.Transaction{TxHash: revealTx1.TxHash().String(), Height: 1001},
+		&indexer.Transaction{TxHash: commitTx3.TxHash().String(), Height: 1001},
+		&indexer.Transaction{TxHash: revealTx3.TxHash().String(), Height: 1001},
+		&indexer.Transaction{TxHash: commitTx2.TxHash().String(), Height: 1002},
+		&indexer.Transaction{TxHash: spoofRevealTx.TxHash().String(), Height: 1003},
+		&indexer.Transaction{TxHash: spoofCommitTx.TxHash().String(), Height: 0},
+	)
+	mockIndexer.On("GetHistory", mock.Anything, pkScript).Return(history, nil)
+	mockIndexer.On("GetHistory", mock.Anything, commitTx1.TxOut[0].PkScript).Return([]*indexer.Transaction{
+		{TxHash: commitTx1.TxHash().String(), Height: 1001},
+		{TxHash: revealTx1.TxHash().String(), Height: 1001},
+	}, nil)
+	mockIndexer.On("GetHistory", mock.Anything, commitTx2.TxOut[0].PkScript).Return([]*indexer.Transaction{
+		{TxHash: commitTx2.TxHash().String(), Height: 1002},
+		{TxHash: revealTx2.TxHash().String(), Height: 1003},
+	}, nil)
+	mockIndexer.On("GetHistory", mock.Anything, commitTx3.TxOut[0].PkScript).Return([]*indexer.Transaction{
+		{TxHash: commitTx3.TxHash().String(), Height: 1001},
+		{TxHash: revealTx3.TxHash().String(), Height: 1001},
+	}, nil)
+	mockIndexer.On("GetHistory", mock.Anything, mock.Anything).Return([]*indexer.Transaction{}, nil)
+	mockIndexer.On("GetMerkle", mock.Anything, revealTx1.TxHash().String(), int32(1001)).Return(&indexer.Merkle{BlockHeight: 1001, Pos: 4}, nil)
+	mockIndexer.On("GetMerkle", mock.Anything, revealTx3.TxHash().String(), int32(1001)).Return(&indexer.Merkle{BlockHeight: 1001, Pos: 2}, nil)
+	mockIndexer.On("GetMerkle", mock.Anything, revealTx2.TxHash().String(), int32(1003)).Return(&indexer.Merkle{BlockHeight: 1003, Pos: 1}, nil)
+
+	scan := func(startHeight int32) []*ScannedInscription {
+		scanned := []*ScannedInscription{}
+		err := btcman.ScanInscriptions(startHeight, func(inscription *ScannedInscription) error {
+			scanned = append(scanned, inscription)
+			return nil
+		})
+		require.NoError(t, err)
+		return scanned
+	}
+
+	scanned := scan(0)
+	require.Len(t, scanned, 3)
+	assert.Equal(t, []byte("third"), scanned[0].Body)
+	assert.Equal(t, 2, scanned[0].Position)
+	assert.Equal(t, []byte("first"), scanned[1].Body)
+	assert.Equal(t, revealTx1.TxHash(), scanned[1].TxHash)
+	assert.EqualValues(t, 1001, scanned[1].Height)
+	assert.Equal(t, 4, scanned[1].Position)
+	assert.Equal(t, InscriptionID{TxHash: revealTx1.TxHash()}.String(), scanned[1].InscriptionID)
+	assert.Equal(t, []byte("second"), scanned[2].Body)
+	assert.Equal(t, revealTx2.TxHash(), scanned[2].TxHash)
+	assert.EqualValues(t, 1003, scanned[2].Height)
+	assert.Equal(t, 1, scanned[2].Position)
+
+	scanned = scan(1002)
+	require.Len(t, scanned, 1)
+	assert.Equal(t, []byte("second"), scanned[0].Body)
+
+	// the commit of the second reveal is found within the lookback
+	scanned = scan(1003)
+	require.Len(t, scanned, 1)
+	assert.Equal(t, []byte("second"), scanned[0].Body)
+	assert.Empty(t, scan(1004))
+
+	stop := errors.New("stop")
+	calls := 0
+	err = btcman.ScanInscriptions(0, func(*ScannedInscription) error {
+		calls++
+		return stop
+	})
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+
+	// an indexer error isn't taken for an unspent commit output
+	indexerErr := errors.New("indexer unavailable")
+	for _, call := range mockIndexer.ExpectedCalls {
+		if call.Method == "GetHistory" && assert.ObjectsAreEqual(commitTx2.TxOut[0].PkScript, call.Arguments.Get(1)) {
+			call.ReturnArguments = mock.Arguments{([]*indexer.Transaction)(nil), indexerErr}
+		}
+	}
+	err = btcman.ScanInscriptions(0, func(*ScannedInscription) error { return nil })
+	assert.ErrorIs(t, err, indexerErr)
+}
@@ -0,0 +1,179 @@
+package btcman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/mempool"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+)
+
+// Output is a payment of a Send transaction
+type Output struct {
+	// Address is the destination address of any type supported by the network
+	Address string
+	// Amount is the value paid in satoshi, ignored by a sweep output
+	Amount int64
+	// Sweep sends all the spendable utxos of the wallet, less the other outputs and the fee, to the address.
+	// At most one output sweeps and the transaction has no change
+	Sweep bool
+}
+
+// Send pays the outputs from the wallet utxos at a fee rate in sat/vbyte. The utxos are selected by the coin
+// selection of the client and the change returns to the wallet address unless it's dust.
+// Returns the hash, size and fee of the sent transaction
+func (client *Client) Send(outputs []Output, feeRate int64) (*TxEstimate, error) {
+	rawOutputs, sweepIndex, err := client.sendOutputs(outputs, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	// the outputs and the transaction fee without inputs, the value sweeping is left out
+	tx, err := client.createRawTransaction(nil, rawOutputs...)
+	if err != nil {
+		return nil, err
+	}
+	amount := int64(0)
+	for _, txOut := range tx.TxOut {
+		amount += txOut.Value
+	}
+
+	var utxos []*indexer.UTXO
+	if sweepIndex >= 0 {
+		utxos, err = client.leaseAllUTXOs(feeRate)
+	} else {
+		changeOutput := wire.NewTxOut(0, client.keychain.GetPkScript())
+		utxos, err = client.leaseUTXOs(CoinSelectionTarget{
+			Amount:     amount + mempool.GetTxVirtualSize(btcutil.NewTx(tx))*feeRate,
+			FeeRate:    feeRate,
+			ChangeCost: int64(changeOutput.SerializeSize()) * feeRate,
+		}, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	inputs := []btcjson.TransactionInput{}
+	totalAmount := int64(0)
+	for _, utxo := range utxos {
+		inputs = append(inputs, btcjson.TransactionInput{Txid: utxo.TxHash, Vout: uint32(utxo.TxPos)})
+		totalAmount += utxo.Value
+	}
+	tx, err = client.createRawTransaction(inputs, rawOutputs...)
+	if err != nil {
+		client.utxoManager.release(outPointsOf(utxos)...)
+		return nil, err
+	}
+
+	if sweepIndex >= 0 {
+		sweepOutput := tx.TxOut[sweepIndex]
+		sweepOutput.Value = totalAmount - amount - client.estimatedVSize(tx, utxos)*feeRate
+		if mempool.IsDust(sweepOutput, mempool.DefaultMinRelayTxFee) {
+			client.utxoManager.release(outPointsOf(utxos)...)
+			return nil, fmt.Errorf("%w: the swept value is dust", ErrInsufficientFunds)
+		}
+	} else {
+		changeOutput := wire.NewTxOut(0, client.keychain.GetPkScript())
+		tx.AddTxOut(changeOutput)
+		changeOutput.Value = totalAmount - amount - client.estimatedVSize(tx, utxos)*feeRate
+		// a dust change is left to the fee
+		if mempool.IsDust(changeOutput, mempool.DefaultMinRelayTxFee) {
+			tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
+			if totalAmount < amount+client.estimatedVSize(tx, utxos)*feeRate {
+				client.utxoManager.release(outPointsOf(utxos)...)
+				return nil, ErrInsufficientFunds
+			}
+		}
+	}
+
+	prevOutputFetcher := NewUtxoPrevOutFetcher(utxos, client.IndexerClient, client.logger)
+	if err := client.keychain.SignTransaction(tx, prevOutputFetcher); err != nil {
+		client.utxoManager.release(outPointsOf(utxos)...)
+		return nil, fmt.Errorf("error signing transaction: %v", err)
+	}
+	estimate := newTxEstimate(tx, prevOutputFetcher)
+
+	txHash, err := client.IndexerClient.SendTransaction(context.Background(), tx)
+	if err != nil {
+		client.utxoManager.release(outPointsOf(utxos)...)
+		return nil, fmt.Errorf("error sending transaction: %v", err)
+	}
+	client.utxoManager.spend(tx, client.keychain.GetPkScripts())
+	hash, err := chainhash.NewHashFromStr(txHash)
+	if err != nil {
+		return nil, err
+	}
+	estimate.TxHash = *hash
+
+	client.logger.Info("Transaction sent successfully", "txHash", txHash, "outputs", len(outputs), "fee", estimate.Fee)
+	return &estimate, nil
+}
+
+// sendOutputs validates the outputs of a Send transaction and returns them with the index of the sweep output, -1 if none
+func (client *Client) sendOutputs(outputs []Output, feeRate int64) ([]rawTxOutput, int, error) {
+	if len(outputs) == 0 {
+		return nil, -1, errors.New("transaction has no outputs")
+	}
+	if feeRate <= 0 {
+		return nil, -1, errors.New("fee rate must be positive")
+	}
+
+	rawOutputs := make([]rawTxOutput, len(outputs))
+	sweepIndex := -1
+	for i, output := range outputs {
+		address, err := btcutil.DecodeAddress(output.Address, client.netParams)
+		if err != nil {
+			return nil, -1, fmt.Errorf("output %d: invalid address: %v", i, err)
+		}
+		if !address.IsForNet(client.netParams) {
+			return nil, -1, fmt.Errorf("output %d: address %s is not for %s", i, output.Address, client.netParams.Name)
+		}
+		rawOutputs[i].address = address
+
+		if output.Sweep {
+			if sweepIndex >= 0 {
+				return nil, -1, errors.New("at most one output can sweep")
+			}
+			sweepIndex = i
+			continue
+		}
+		pkScript, err := txscript.PayToAddrScript(address)
+		if err != nil {
+			return nil, -1, fmt.Errorf("output %d: %v", i, err)
+		}
+		if mempool.IsDust(wire.NewTxOut(output.Amount, pkScript), mempool.DefaultMinRelayTxFee) {
+			return nil, -1, fmt.Errorf("output %d: amount %d is dust", i, output.Amount)
+		}
+		rawOutputs[i].amount = btcutil.Amount(output.Amount)
+	}
+	return rawOutputs, sweepIndex, nil
+}
+
+// leaseAllUTXOs leases the spendable utxos worth spending at the fee rate
+func (client *Client) leaseAllUTXOs(feeRate int64) ([]*indexer.UTXO, error) {
+	utxos, err := client.spendableUTXOs()
+	if err != nil {
+		return nil, err
+	}
+	leased := []*indexer.UTXO{}
+	for _, utxo := range utxos {
+		if utxo.Value <= client.inputVSize(utxo.PkScript)*feeRate {
+			continue
+		}
+		outPoint, err := utxoOutPoint(utxo)
+		if err != nil || !client.utxoManager.lease(outPoint) {
+			continue
+		}
+		leased = append(leased, utxo)
+	}
+	if len(leased) == 0 {
+		return nil, fmt.Errorf("%w: there are no utxos to sweep", ErrInsufficientFunds)
+	}
+	return leased, nil
+}
@@ -0,0 +1,138 @@
+package btcman
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newSendTestAddresses returns regtest addresses of several types
+func newSendTestAddresses(t *testing.T) map[string]btcutil.Address {
+	params := &chaincfg.RegressionNetParams
+	keyHash := bytes.Repeat([]byte{0x01}, 20)
+	p2pkh, err := btcutil.NewAddressPubKeyHash(keyHash, params)
+	require.NoError(t, err)
+	p2sh, err := btcutil.NewAddressScriptHashFromHash(keyHash, params)
+	require.NoError(t, err)
+	p2wsh, err := btcutil.NewAddressWitnessScriptHash(bytes.Repeat([]byte{0x02}, 32), params)
+	require.NoError(t, err)
+	p2tr, err := btcutil.NewAddressTaproot(bytes.Repeat([]byte{0x03}, 32), params)
+	require.NoError(t, err)
+	return map[string]btcutil.Address{"p2pkh": p2pkh, "p2sh": p2sh, "p2wsh": p2wsh, "p2tr": p2tr}
+}
+
+func mockSentTransactions(mockIndexer *mocks.Indexer) *[]*wire.MsgTx {
+	sent := []*wire.MsgTx{}
+	mockIndexer.On("SendTransaction", mock.Anything, mock.Anything).Return(func(tx *wire.MsgTx) string {
+		sent = append(sent, tx)
+		return tx.TxHash().String()
+	}, nil)
+	return &sent
+}
+
+func TestSend(t *testing.T) {
+	for name, address := range newSendTestAddresses(t) {
+		t.Run(name, func(t *testing.T) {
+			mockIndexer := new(mocks.Indexer)
+			btcman := newTestWriterClient(t, mockIndexer, 30_000, 80_000)
+			sent := mockSentTransactions(mockIndexer)
+
+			estimate, err := btcman.Send([]Output{{Address: address.EncodeAddress(), Amount: 50_000}}, 5)
+			require.NoError(t, err)
+			require.Len(t, *sent, 1)
+			tx := (*sent)[0]
+			assert.Equal(t, tx.TxHash(), estimate.TxHash)
+
+			pkScript, err := txscript.PayToAddrScript(address)
+			require.NoError(t, err)
+			require.Len(t, tx.TxOut, 2)
+			assert.Equal(t, pkScript, tx.TxOut[0].PkScript)
+			assert.EqualValues(t, 50_000, tx.TxOut[0].Value)
+			assert.Equal(t, btcman.keychain.GetPkScript(), tx.TxOut[1].PkScript)
+
+			values := map[chainhash.Hash]int64{chainhash.HashH([]byte("funding 0")): 30_000, chainhash.HashH([]byte("funding 1")): 80_000}
+			inputValue := int64(0)
+			for _, txIn := range tx.TxIn {
+				assert.NotEmpty(t, txIn.Witness)
+				inputValue += values[txIn.PreviousOutPoint.Hash]
+			}
+			assert.Equal(t, inputValue-50_000-tx.TxOut[1].Value, estimate.Fee)
+			assert.GreaterOrEqual(t, estimate.Fee, estimate.VSize*5)
+		})
+	}
+}
+
+func TestSendSweep(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 30_000, 80_000, 100)
+	sent := mockSentTransactions(mockIndexer)
+	addresses := newSendTestAddresses(t)
+
+	estimate, err := btcman.Send([]Output{
+		{Address: addresses["p2wsh"].EncodeAddress(), Amount: 10_000},
+		{Address: addresses["p2tr"].EncodeAddress(), Sweep: true},
+	}, 2)
+	require.NoError(t, err)
+	require.Len(t, *sent, 1)
+	tx := (*sent)[0]
+	// the utxo not worth spending is left
+	require.Len(t, tx.TxIn, 2)
+	require.Len(t, tx.TxOut, 2)
+	assert.EqualValues(t, 10_000, tx.TxOut[0].Value)
+	assert.EqualValues(t, 110_000-10_000-estimate.Fee, tx.TxOut[1].Value)
+
+	// every utxo is leased by the sweep
+	_, err = btcman.Send([]Output{{Address: addresses["p2tr"].EncodeAddress(), Sweep: true}}, 2)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+}
+
+func TestSendInvalidOutputs(t *testing.T) {
+	addresses := newSendTestAddresses(t)
+	mainnetAddress, err := btcutil.NewAddressTaproot(bytes.Repeat([]byte{0x03}, 32), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		outputs []Output
+		feeRate int64
+	}{
+		{name: "no outputs", feeRate: 2},
+		{name: "no fee rate", outputs: []Output{{Address: addresses["p2tr"].EncodeAddress(), Amount: 10_000}}},
+		{name: "other network", outputs: []Output{{Address: mainnetAddress.EncodeAddress(), Amount: 10_000}}, feeRate: 2},
+		{name: "invalid address", outputs: []Output{{Address: "address", Amount: 10_000}}, feeRate: 2},
+		{name: "dust", outputs: []Output{{Address: addresses["p2tr"].EncodeAddress(), Amount: 100}}, feeRate: 2},
+		{
+			name: "two sweeps",
+			outputs: []Output{
+				{Address: addresses["p2tr"].EncodeAddress(), Sweep: true},
+				{Address: addresses["p2wsh"].EncodeAddress(), Sweep: true},
+			},
+			feeRate: 2,
+		},
+		{name: "insufficient funds", outputs: []Output{{Address: addresses["p2tr"].EncodeAddress(), Amount: 100_000}}, feeRate: 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockIndexer := new(mocks.Indexer)
+			btcman := newTestWriterClient(t, mockIndexer, 30_000, 50_000)
+
+			_, err := btcman.Send(tc.outputs, tc.feeRate)
+			assert.Error(t, err)
+			mockIndexer.AssertNotCalled(t, "SendTransaction", mock.Anything, mock.Anything)
+			// the utxos are released
+			utxos, err := btcman.spendableUTXOs()
+			require.NoError(t, err)
+			assert.Len(t, utxos, 2)
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package btcman
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/grail-rollup/btcman/common"
+	"github.com/ledgerwatch/log/v3"
+)
+
+// ErrCheckpointReorged is returned when the block of the checkpoint is no longer part of the best chain
+var ErrCheckpointReorged = errors.New("checkpoint block is no longer in the best chain")
+
+// errSyncEndReached stops the scan once the inscriptions are past the end height
+var errSyncEndReached = errors.New("sync end height reached")
+
+// Checkpoint is the last block whose inscriptions were all handled by the sync
+type Checkpoint struct {
+	Height    int32          `json:"height"`
+	BlockHash chainhash.Hash `json:"blockHash"`
+}
+
+// CheckpointStore persists the checkpoint of a sync
+type CheckpointStore interface {
+	// Load returns the saved checkpoint, nil if there is none
+	Load() (*Checkpoint, error)
+	Save(checkpoint Checkpoint) error
+}
+
+// SyncConfig is the block range of a sync
+type SyncConfig struct {
+	// StartHeight is the first height synced when there is no checkpoint
+	StartHeight int32
+	// EndHeight is the last height synced, the chain tip if 0
+	EndHeight int32
+}
+
+// InscriptionSync handles the inscriptions of the publisher block by block and checkpoints the handled blocks,
+// so a restarted sync resumes after the last checkpoint
+type InscriptionSync struct {
+	client Clienter
+	store  CheckpointStore
+	cfg    SyncConfig
+	logger log.Logger
+}
+
+// NewInscriptionSync creates a sync of the inscriptions of the client publisher
+func NewInscriptionSync(client Clienter, store CheckpointStore, cfg SyncConfig, parentLogger log.Logger) *InscriptionSync {
+	return &InscriptionSync{
+		client: client,
+		store:  store,
+		cfg:    cfg,
+		logger: parentLogger.New("module", common.SYNC),
+	}
+}
+
+// Sync calls handle with the inscriptions from the checkpoint, or the start height, up to the end height or the chain tip,
+// in block order. A checkpoint is saved once all the inscriptions of a block are handled, an inscription of a block
+// not checkpointed yet is handled again after a restart. An error of handle stops the sync and is returned,
+// the blocks before the failed inscription stay checkpointed. Returns the last checkpoint
+func (s *InscriptionSync) Sync(handle func(*ScannedInscription) error) (*Checkpoint, error) {
+	checkpoint, err := s.store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading checkpoint: %v", err)
+	}
+
+	startHeight := s.cfg.StartHeight
+	if checkpoint != nil {
+		blockHash, err := s.blockHash(checkpoint.Height)
+		if err != nil {
+			return nil, err
+		}
+		if *blockHash != checkpoint.BlockHash {
+			return checkpoint, fmt.Errorf("%w: height %d", ErrCheckpointReorged, checkpoint.Height)
+		}
+		if checkpoint.Height >= startHeight {
+			startHeight = checkpoint.Height + 1
+		}
+	}
+
+	tip, err := s.client.GetBlockchainHeight()
+	if err != nil {
+		return checkpoint, err
+	}
+	endHeight := tip
+	if s.cfg.EndHeight > 0 && s.cfg.EndHeight < tip {
+		endHeight = s.cfg.EndHeight
+	}
+	if startHeight > endHeight {
+		return checkpoint, nil
+	}
+	s.logger.Info("Syncing inscriptions", "startHeight", startHeight, "endHeight", endHeight)
+
+	handled := 0
+	err = s.client.ScanInscriptions(startHeight, func(inscription *ScannedInscription) error {
+		if inscription.Height > endHeight {
+			return errSyncEndReached
+		}
+		// the blocks before the inscription are all handled
+		if previous := inscription.Height - 1; previous >= startHeight && (checkpoint == nil || previous > checkpoint.Height) {
+			saved, err := s.save(previous)
+			if err != nil {
+				return err
+			}
+			checkpoint = saved
+		}
+		if err := handle(inscription); err != nil {
+			return err
+		}
+		handled++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errSyncEndReached) {
+		return checkpoint, err
+	}
+
+	saved, err := s.save(endHeight)
+	if err != nil {
+		return checkpoint, err
+	}
+	checkpoint = saved
+	s.logger.Info("Inscriptions synced", "inscriptions", handled, "height", endHeight)
+	return checkpoint, nil
+}
+
+// Rollback moves the checkpoint back to the fork point of a reorg, so the next sync handles the inscriptions
+// of the new blocks. The handler must drop what it derived from the inscriptions of the orphaned blocks
+func (s *InscriptionSync) Rollback(event *ReorgEvent) error {
+	checkpoint, err := s.store.Load()
+	if err != nil {
+		return fmt.Errorf("error loading checkpoint: %v", err)
+	}
+	if checkpoint == nil || checkpoint.Height <= event.ForkHeight {
+		return nil
+	}
+	s.logger.Warn("Rolling back checkpoint", "height", checkpoint.Height, "forkHeight", event.ForkHeight)
+	if err := s.store.Save(Checkpoint{Height: event.ForkHeight, BlockHash: event.ForkHash}); err != nil {
+		return fmt.Errorf("error saving checkpoint: %v", err)
+	}
+	return nil
+}
+
+// save persists the checkpoint of a block
+func (s *InscriptionSync) save(height int32) (*Checkpoint, error) {
+	blockHash, err := s.blockHash(height)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &Checkpoint{Height: height, BlockHash: *blockHash}
+	if err := s.store.Save(*checkpoint); err != nil {
+		return nil, fmt.Errorf("error saving checkpoint: %v", err)
+	}
+	return checkpoint, nil
+}
+
+// blockHash returns the hash of the best chain block at a height
+func (s *InscriptionSync) blockHash(height int32) (*chainhash.Hash, error) {
+	header, err := s.client.GetBlockHeader(uint64(height))
+	if err != nil {
+		return nil, err
+	}
+	blockHash := header.BlockHash()
+	return &blockHash, nil
+}
+
+// fileCheckpointStore saves the checkpoint as JSON in a file
+type fileCheckpointStore struct {
+	path string
+}
+
+// NewFileCheckpointStore returns a checkpoint store saving the checkpoint in the file at path
+func NewFileCheckpointStore(path string) CheckpointStore {
+	return &fileCheckpointStore{path: path}
+}
+
+// Load reads the checkpoint file, nil if it doesn't exist
+func (f *fileCheckpointStore) Load() (*Checkpoint, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// Save replaces the checkpoint file, the checkpoint is written to a temporary file first
+// so a crash never leaves a partial checkpoint
+func (f *fileCheckpointStore) Save(checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,117 @@
+package btcman
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ledgerwatch/log/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncTestClient serves scanned inscriptions and block headers whose hash depends on the height and the fork
+type syncTestClient struct {
+	Clienter
+	inscriptions []*ScannedInscription
+	tip          int32
+	fork         uint32
+}
+
+func (c *syncTestClient) ScanInscriptions(startHeight int32, handle func(*ScannedInscription) error) error {
+	for _, inscription := range c.inscriptions {
+		if inscription.Height < startHeight {
+			continue
+		}
+		if err := handle(inscription); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *syncTestClient) GetBlockchainHeight() (int32, error) {
+	return c.tip, nil
+}
+
+func (c *syncTestClient) GetBlockHeader(height uint64) (*wire.BlockHeader, error) {
+	return &wire.BlockHeader{Nonce: uint32(height), Bits: c.fork}, nil
+}
+
+func newSyncTestInscription(height int32, body string) *ScannedInscription {
+	return &ScannedInscription{Inscription: &Inscription{Body: []byte(body)}, Height: height}
+}
+
+func TestInscriptionSync(t *testing.T) {
+	client := &syncTestClient{
+		inscriptions: []*ScannedInscription{
+			newSyncTestInscription(101, "a"),
+			newSyncTestInscription(101, "b"),
+			newSyncTestInscription(103, "c"),
+			newSyncTestInscription(110, "d"),
+		},
+		tip: 105,
+	}
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	handled := []string{}
+	handle := func(inscription *ScannedInscription) error {
+		handled = append(handled, string(inscription.Body))
+		return nil
+	}
+
+	checkpoint, err := NewInscriptionSync(client, store, SyncConfig{StartHeight: 100}, log.New("testing")).Sync(handle)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, handled)
+	assert.EqualValues(t, 105, checkpoint.Height)
+	header, _ := client.GetBlockHeader(105)
+	assert.Equal(t, header.BlockHash(), checkpoint.BlockHash)
+
+	// a restarted sync resumes from the saved checkpoint
+	saved, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, checkpoint, saved)
+	client.tip = 112
+	checkpoint, err = NewInscriptionSync(client, store, SyncConfig{StartHeight: 100}, log.New("testing")).Sync(handle)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, handled)
+	assert.EqualValues(t, 112, checkpoint.Height)
+
+	// the checkpoint block was replaced by a reorg
+	client.fork = 1
+	_, err = NewInscriptionSync(client, store, SyncConfig{StartHeight: 100}, log.New("testing")).Sync(handle)
+	assert.ErrorIs(t, err, ErrCheckpointReorged)
+}
+
+func TestInscriptionSyncHandlerFailure(t *testing.T) {
+	client := &syncTestClient{
+		inscriptions: []*ScannedInscription{
+			newSyncTestInscription(101, "a"),
+			newSyncTestInscription(103, "b"),
+			newSyncTestInscription(103, "c"),
+		},
+		tip: 110,
+	}
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	failure := errors.New("failure")
+	handled := []string{}
+	fail := true
+	handle := func(inscription *ScannedInscription) error {
+		if string(inscription.Body) == "c" && fail {
+			return failure
+		}
+		handled = append(handled, string(inscription.Body))
+		return nil
+	}
+
+	checkpoint, err := NewInscriptionSync(client, store, SyncConfig{StartHeight: 100, EndHeight: 105}, log.New("testing")).Sync(handle)
+	assert.ErrorIs(t, err, failure)
+	assert.EqualValues(t, 102, checkpoint.Height)
+
+	// the inscriptions of the block not checkpointed are handled again
+	fail = false
+	checkpoint, err = NewInscriptionSync(client, store, SyncConfig{StartHeight: 100, EndHeight: 105}, log.New("testing")).Sync(handle)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "b", "c"}, handled)
+	assert.EqualValues(t, 105, checkpoint.Height)
+}
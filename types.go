@@ -1,5 +1,13 @@
 package btcman
 
+import (
+	"errors"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/fxamacker/cbor/v2"
+)
+
 // BtcmanMode is the mode of the btcman
 type BtcmanMode string
 
@@ -8,3 +16,152 @@ const (
 	WriterMode  BtcmanMode = "writer"
 	InvalidMode BtcmanMode = "invalid"
 )
+
+// AddressType is the type of address the btcman wallet receives and spends from
+type AddressType string
+
+const (
+	P2PKHAddress      AddressType = "p2pkh"
+	P2SHP2WPKHAddress AddressType = "p2sh-p2wpkh"
+	P2WPKHAddress     AddressType = "p2wpkh"
+	P2TRAddress       AddressType = "p2tr"
+	P2WSHAddress      AddressType = "p2wsh"
+	InvalidAddress    AddressType = "invalid"
+)
+
+// spendableAddressTypes are the address types a single key keychain is able to sign for
+var spendableAddressTypes = []AddressType{P2PKHAddress, P2SHP2WPKHAddress, P2WPKHAddress, P2TRAddress}
+
+// defaultContentType is the content type of inscriptions without a configured one
+const defaultContentType = "application/octet-stream"
+
+// InscribeOptions are the optional envelope fields of an inscription
+type InscribeOptions struct {
+	// ContentType of the body, defaults to application/octet-stream
+	ContentType string
+	// ContentEncoding of the body, e.g. br for a brotli compressed body
+	ContentEncoding string
+	// Compression compresses the body and sets the content encoding, the body is inscribed
+	// uncompressed if compression doesn't make it smaller
+	Compression Compression
+	// Chunked splits the body across several inscriptions revealed by separate transactions of the same commit,
+	// every chunk is tagged with its ChunkMetadata. Required for bodies that don't fit in a standard reveal transaction
+	Chunked bool
+	// ChunkSize is the maximum body size of a chunk, defaults to DefaultChunkSize
+	ChunkSize int
+	// SingleReveal reveals all the inscriptions of a batch with one transaction instead of one transaction each
+	SingleReveal bool
+	// Destination is the address receiving the inscriptions, defaults to the inscription holding address
+	// of the config or the wallet address
+	Destination string
+	// RevealOutValue is the value of the outputs receiving the inscriptions, defaults to the value of the config
+	// or the minimal value that isn't dust for the destination
+	RevealOutValue int64
+	// RevealTag is the data of an OP_RETURN output added to the reveal transactions, e.g. a short commitment
+	RevealTag []byte
+	// PublishMode selects an inscription or OP_RETURN outputs, the envelope and reveal options don't apply to OP_RETURN outputs
+	PublishMode PublishMode
+	// DryRun builds and signs the transactions and returns their raw hex instead of sending them. The inputs of a multisig
+	// or musig2 wallet get placeholder witnesses of the signed size, the cosigners aren't reached and the result isn't Signed
+	DryRun bool
+	// Metaprotocol labels the inscription with the protocol it belongs to
+	Metaprotocol string
+	// Metadata is encoded as CBOR into the inscription metadata
+	Metadata interface{}
+	// Pointer is the offset of the sat the inscription is made on
+	Pointer *uint64
+	// Parent is the parent inscription, it must be spent by the reveal transaction in order to be recognized
+	Parent *InscriptionID
+	// Delegate is the inscription whose content is shown instead of the body
+	Delegate *InscriptionID
+}
+
+// InscribeResult holds the transactions and the inscriptions created by an inscribe call
+type InscribeResult struct {
+	CommitTxHash     *chainhash.Hash
+	RevealTxHashList []*chainhash.Hash
+	InscriptionIDs   []string
+	Fees             int64
+	// Items are the results of every inscription, in the order of the inscribed payloads
+	Items []InscribeItemResult
+	// CommitTxHex and RevealTxHexList are the transactions of a dry run
+	CommitTxHex     string
+	RevealTxHexList []string
+	// Signed is false for the dry run of a multisig or musig2 wallet, its wallet inputs have placeholder witnesses
+	// and the transactions can't be sent
+	Signed bool
+}
+
+// InscribeItemResult is the result of a single inscription of an inscribe call
+type InscribeItemResult struct {
+	RevealTxHash  *chainhash.Hash
+	InscriptionID string
+	// RevealFee is the part of the reveal transaction fee paid for the inscription
+	RevealFee int64
+	// Err is set if the reveal transaction of the inscription wasn't sent
+	Err error
+}
+
+// inscriptionData returns the inscription data of a body sent to destination
+func (opts InscribeOptions) inscriptionData(body []byte, destination string) (InscriptionData, error) {
+	contentType := opts.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	body, contentEncoding, err := opts.encodeBody(body)
+	if err != nil {
+		return InscriptionData{}, err
+	}
+	var metadata []byte
+	if opts.Metadata != nil {
+		metadata, err = cbor.Marshal(opts.Metadata)
+		if err != nil {
+			return InscriptionData{}, err
+		}
+	}
+
+	return InscriptionData{
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding,
+		Metadata:        metadata,
+		Metaprotocol:    opts.Metaprotocol,
+		Pointer:         opts.Pointer,
+		Parent:          opts.Parent,
+		Delegate:        opts.Delegate,
+		Body:            body,
+		Destination:     destination,
+	}, nil
+}
+
+// encodeBody compresses the body by the compression of the options and returns it with its content encoding,
+// the content encoding of the options if there's no compression
+func (opts InscribeOptions) encodeBody(body []byte) ([]byte, string, error) {
+	if opts.Compression == NoCompression {
+		return body, opts.ContentEncoding, nil
+	}
+	if opts.ContentEncoding != "" {
+		return nil, "", errors.New("content encoding can't be set together with compression")
+	}
+	return compressBody(body, opts.Compression)
+}
+
+// TxEstimate is the size and the fee of a transaction
+type TxEstimate struct {
+	TxHash chainhash.Hash
+	VSize  int64
+	Weight int64
+	Fee    int64
+}
+
+// InscriptionEstimate is the cost of an inscription whose transactions were built but not sent
+type InscriptionEstimate struct {
+	CommitTx  TxEstimate
+	RevealTxs []TxEstimate
+	// Inputs are the outpoints funding the commit transaction and InputValue their total value
+	Inputs     []wire.OutPoint
+	InputValue int64
+	// Change is the value returned to the wallet by the commit transaction, 0 if there is no change output
+	Change int64
+	// Fees is the total fee of the commit and reveal transactions
+	Fees int64
+}
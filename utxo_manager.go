@@ -0,0 +1,174 @@
+package btcman
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+)
+
+const (
+	// inFlightLeaseTimeout is the time after which a lease of an outpoint not spent yet is dropped
+	inFlightLeaseTimeout = 10 * time.Minute
+	// spentLeaseTimeout is the time after which a lease of a spent outpoint is dropped even if
+	// the indexer still lists it, e.g. the spending transaction was evicted from the mempool
+	spentLeaseTimeout = time.Hour
+)
+
+// utxoLease is the reservation of an outpoint by a transaction being built or already sent
+type utxoLease struct {
+	expiry  time.Time
+	spentBy *chainhash.Hash
+}
+
+// utxoManager leases the wallet outpoints to in flight inscriptions and consolidations,
+// so concurrent transactions never spend the same outpoint
+type utxoManager struct {
+	lock                   sync.Mutex
+	leases                 map[wire.OutPoint]*utxoLease
+	change                 map[wire.OutPoint]*indexer.UTXO
+	allowUnconfirmedChange bool
+	// coinbase caches whether the funding transactions of the utxos are coinbase
+	coinbase map[chainhash.Hash]bool
+}
+
+func newUtxoManager(allowUnconfirmedChange bool) *utxoManager {
+	return &utxoManager{
+		leases:                 make(map[wire.OutPoint]*utxoLease),
+		change:                 make(map[wire.OutPoint]*indexer.UTXO),
+		allowUnconfirmedChange: allowUnconfirmedChange,
+		coinbase:               make(map[chainhash.Hash]bool),
+	}
+}
+
+// available returns the utxos that are not leased, extended by the unconfirmed change of our own
+// transactions if allowed. Leases of outpoints no longer listed by the indexer are released.
+func (m *utxoManager) available(utxos []*indexer.UTXO) []*indexer.UTXO {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	listed := make(map[wire.OutPoint]*indexer.UTXO, len(utxos))
+	for _, utxo := range utxos {
+		outPoint, err := utxoOutPoint(utxo)
+		if err != nil {
+			continue
+		}
+		listed[outPoint] = utxo
+		// the indexer knows the change output, it's no longer tracked
+		delete(m.change, outPoint)
+	}
+	for outPoint, lease := range m.leases {
+		_, isListed := listed[outPoint]
+		if (lease.spentBy != nil && !isListed) || now.After(lease.expiry) {
+			delete(m.leases, outPoint)
+		}
+	}
+
+	available := []*indexer.UTXO{}
+	for _, utxo := range utxos {
+		outPoint, err := utxoOutPoint(utxo)
+		if err != nil {
+			continue
+		}
+		if _, ok := m.leases[outPoint]; !ok {
+			available = append(available, utxo)
+		}
+	}
+	if m.allowUnconfirmedChange {
+		for outPoint, utxo := range m.change {
+			if _, ok := m.leases[outPoint]; !ok {
+				available = append(available, utxo)
+			}
+		}
+	}
+	return available
+}
+
+// lease reserves an outpoint, false if it's already leased
+func (m *utxoManager) lease(outPoint wire.OutPoint) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if lease, ok := m.leases[outPoint]; ok && time.Now().Before(lease.expiry) {
+		return false
+	}
+	m.leases[outPoint] = &utxoLease{expiry: time.Now().Add(inFlightLeaseTimeout)}
+	return true
+}
+
+// release drops the leases of outpoints whose transaction failed, change outputs spent by it are dropped
+// as well since their transaction may not be valid anymore
+func (m *utxoManager) release(outPoints ...wire.OutPoint) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, outPoint := range outPoints {
+		delete(m.leases, outPoint)
+		delete(m.change, outPoint)
+	}
+}
+
+// spend marks the leased inputs of a sent transaction as spent and tracks its outputs paying walletPkScripts as change
+func (m *utxoManager) spend(tx *wire.MsgTx, walletPkScripts [][]byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	txHash := tx.TxHash()
+	for _, txIn := range tx.TxIn {
+		m.leases[txIn.PreviousOutPoint] = &utxoLease{
+			expiry:  time.Now().Add(spentLeaseTimeout),
+			spentBy: &txHash,
+		}
+		delete(m.change, txIn.PreviousOutPoint)
+	}
+	for index, txOut := range tx.TxOut {
+		for _, pkScript := range walletPkScripts {
+			if bytes.Equal(txOut.PkScript, pkScript) {
+				m.change[wire.OutPoint{Hash: txHash, Index: uint32(index)}] = &indexer.UTXO{
+					TxHash:   txHash.String(),
+					TxPos:    index,
+					Value:    txOut.Value,
+					PkScript: pkScript,
+				}
+				break
+			}
+		}
+	}
+}
+
+// isCoinbase returns whether a funding transaction is coinbase, false if it's not cached yet
+func (m *utxoManager) isCoinbase(txHash chainhash.Hash) (isCoinbase bool, ok bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	isCoinbase, ok = m.coinbase[txHash]
+	return isCoinbase, ok
+}
+
+// setCoinbase caches whether a funding transaction is coinbase
+func (m *utxoManager) setCoinbase(txHash chainhash.Hash, isCoinbase bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.coinbase[txHash] = isCoinbase
+}
+
+// utxoOutPoint returns the outpoint of a utxo
+func utxoOutPoint(utxo *indexer.UTXO) (wire.OutPoint, error) {
+	hash, err := chainhash.NewHashFromStr(utxo.TxHash)
+	if err != nil {
+		return wire.OutPoint{}, err
+	}
+	return *wire.NewOutPoint(hash, uint32(utxo.TxPos)), nil
+}
+
+// txOutPoints returns the outpoints spent by a transaction
+func txOutPoints(tx *wire.MsgTx) []wire.OutPoint {
+	outPoints := make([]wire.OutPoint, len(tx.TxIn))
+	for i, txIn := range tx.TxIn {
+		outPoints[i] = txIn.PreviousOutPoint
+	}
+	return outPoints
+}
@@ -0,0 +1,87 @@
+package btcman
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/grail-rollup/btcman/indexer"
+	"github.com/grail-rollup/btcman/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestUtxo(name string, value int64) *indexer.UTXO {
+	return &indexer.UTXO{TxHash: chainhash.HashH([]byte(name)).String(), TxPos: 1, Value: value, Height: 1}
+}
+
+func TestUtxoManagerLease(t *testing.T) {
+	manager := newUtxoManager(false)
+	utxos := []*indexer.UTXO{newTestUtxo("a", 50000), newTestUtxo("b", 60000)}
+
+	first, err := utxoOutPoint(utxos[0])
+	require.NoError(t, err)
+	assert.True(t, manager.lease(first))
+	assert.False(t, manager.lease(first))
+	assert.Equal(t, []*indexer.UTXO{utxos[1]}, manager.available(utxos))
+
+	manager.release(first)
+	assert.Len(t, manager.available(utxos), 2)
+}
+
+func TestUtxoManagerSpend(t *testing.T) {
+	walletPkScript := []byte{0x00, 0x14, 0x01}
+	utxos := []*indexer.UTXO{newTestUtxo("a", 50000)}
+	outPoint, err := utxoOutPoint(utxos[0])
+	require.NoError(t, err)
+
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(&outPoint, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(10000, []byte{0x51}))
+	tx.AddTxOut(wire.NewTxOut(39000, walletPkScript))
+
+	for _, allowUnconfirmedChange := range []bool{false, true} {
+		manager := newUtxoManager(allowUnconfirmedChange)
+		require.True(t, manager.lease(outPoint))
+		manager.spend(tx, [][]byte{walletPkScript})
+
+		// the spent utxo stays leased while the indexer still lists it
+		available := manager.available(utxos)
+		if !allowUnconfirmedChange {
+			assert.Empty(t, available)
+			continue
+		}
+		require.Len(t, available, 1)
+		assert.Equal(t, tx.TxHash().String(), available[0].TxHash)
+		assert.Equal(t, 1, available[0].TxPos)
+		assert.Equal(t, walletPkScript, available[0].PkScript)
+
+		// once the indexer lists the change and no longer lists the spent utxo both are forgotten
+		change := &indexer.UTXO{TxHash: tx.TxHash().String(), TxPos: 1, Value: 39000}
+		assert.Equal(t, []*indexer.UTXO{change}, manager.available([]*indexer.UTXO{change}))
+		assert.Empty(t, manager.leases)
+		assert.Empty(t, manager.change)
+	}
+}
+
+func TestSelectCommitTxOutPointsLeases(t *testing.T) {
+	mockIndexer := new(mocks.Indexer)
+	btcman := newTestWriterClient(t, mockIndexer, 1_000_000)
+	request := &InscriptionRequest{
+		CommitFeeRate: 3,
+		FeeRate:       2,
+		DataList:      []InscriptionData{{ContentType: defaultContentType, Body: []byte("batch"), Destination: (*btcman.address).String()}},
+	}
+
+	outPoints, err := btcman.selectCommitTxOutPoints(request)
+	require.NoError(t, err)
+	require.Len(t, outPoints, 1)
+
+	// the only utxo is leased to the first caller
+	_, err = btcman.selectCommitTxOutPoints(request)
+	assert.Error(t, err)
+
+	btcman.utxoManager.release(*outPoints[0])
+	_, err = btcman.selectCommitTxOutPoints(request)
+	assert.NoError(t, err)
+}